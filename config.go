@@ -23,6 +23,15 @@ type ConfigElement struct {
 	Elems []*ConfigElement
 }
 
+// Type returns the plugin type given by either "@type" or "type".
+func (elem *ConfigElement) Type() string {
+	type_, ok := elem.Attrs["@type"]
+	if !ok {
+		type_ = elem.Attrs["type"]
+	}
+	return type_
+}
+
 type LineReader interface {
 	Next() (string, error)
 	Close() error
@@ -50,7 +59,7 @@ type DefaultOpener http.Dir
 var (
 	stripCommentRegexp = regexp.MustCompile("\\s*(?:#.*)?$")
 	startTagRegexp     = regexp.MustCompile("^<([a-zA-Z0-9_]+)\\s*(.+?)?>$")
-	attrRegExp         = regexp.MustCompile("^([a-zA-Z0-9_]+)\\s+(.*)$")
+	attrRegExp         = regexp.MustCompile("^(@?[a-zA-Z0-9_]+)\\s+(.*)$")
 )
 
 func (reader *DefaultLineReader) Next() (string, error) {
@@ -243,20 +252,52 @@ func ParseConfig(opener Opener, filename string) (*Config, error) {
 type FluentConfigurer struct {
 	logger                Logger
 	router                *FluentRouter
+	labels                map[string]*FluentRouter
 	inputFactoryRegistry  InputFactoryRegistry
 	outputFactoryRegistry OutputFactoryRegistry
+	filterFactoryRegistry FilterFactoryRegistry
 }
 
-func (configurer *FluentConfigurer) Configure(engine Engine, config *Config) error {
-	for _, v := range config.Root.Elems {
+// labelledEngine makes the plugin emit records to the router of the label
+// designated by "@label".
+type labelledEngine struct {
+	Engine
+	port Port
+}
+
+func (engine *labelledEngine) DefaultPort() Port {
+	return engine.port
+}
+
+func (configurer *FluentConfigurer) engineFor(engine Engine, config *ConfigElement) (Engine, error) {
+	name, ok := config.Attrs["@label"]
+	if !ok {
+		return engine, nil
+	}
+	router, ok := configurer.labels[name]
+	if !ok {
+		return nil, errors.New("Could not find label: " + name)
+	}
+	return &labelledEngine{engine, router}, nil
+}
+
+func (configurer *FluentConfigurer) configureElements(engine Engine, elems []*ConfigElement, router *FluentRouter, label string) error {
+	for _, v := range elems {
 		switch v.Name {
 		case "source":
-			type_ := v.Attrs["type"]
+			if label != "" {
+				return errors.New(fmt.Sprintf("<source> is not allowed in <label %s>", label))
+			}
+			type_ := v.Type()
 			inputFactory := configurer.inputFactoryRegistry.LookupInputFactory(type_)
 			if inputFactory == nil {
 				return errors.New("Could not find input factory: " + type_)
 			}
-			input, err := inputFactory.New(engine, v)
+			engine_, err := configurer.engineFor(engine, v)
+			if err != nil {
+				return err
+			}
+			input, err := inputFactory.New(engine_, v)
 			if err != nil {
 				return err
 			}
@@ -265,32 +306,108 @@ func (configurer *FluentConfigurer) Configure(engine Engine, config *Config) err
 				return err
 			}
 			configurer.logger.Info("Input plugin loaded: %s", inputFactory.Name())
+		case "filter":
+			type_ := v.Type()
+			filterFactory := configurer.filterFactoryRegistry.LookupFilterFactory(type_)
+			if filterFactory == nil {
+				return errors.New("Could not find filter factory: " + type_)
+			}
+			engine_, err := configurer.engineFor(engine, v)
+			if err != nil {
+				return err
+			}
+			filter, err := filterFactory.New(engine_, v)
+			if err != nil {
+				return err
+			}
+			err = router.AddFilter(v.Args, filter)
+			if err != nil {
+				return err
+			}
+			err = engine.Launch(filter)
+			if err != nil {
+				return err
+			}
+			configurer.logger.Info("Filter plugin loaded: %s, with Args '%s'", filterFactory.Name(), v.Args)
 		case "match":
-			type_ := v.Attrs["type"]
+			type_ := v.Type()
 			outputFactory := configurer.outputFactoryRegistry.LookupOutputFactory(type_)
 			if outputFactory == nil {
 				return errors.New("Could not find output factory: " + type_)
 			}
-			output, err := outputFactory.New(engine, v)
+			engine_, err := configurer.engineFor(engine, v)
+			if err != nil {
+				return err
+			}
+			output, err := outputFactory.New(engine_, v)
+			if err != nil {
+				return err
+			}
+			unreachable, err := router.AddRule(v.Args, output)
 			if err != nil {
 				return err
 			}
-			configurer.router.AddRule(v.Args, output)
+			if unreachable {
+				configurer.logger.Warning("<match %s> is never reached; the preceding <match> directives catch every tag it matches", v.Args)
+			}
 			err = engine.Launch(output)
 			if err != nil {
 				return err
 			}
 			configurer.logger.Info("Output plugin loaded: %s, with Args '%s'", outputFactory.Name(), v.Args)
+		case "label":
+			if label != "" {
+				return errors.New(fmt.Sprintf("<label %s> is not allowed in <label %s>", v.Args, label))
+			}
+			router_ := configurer.labels[v.Args]
+			// the plugins in the label emit to the label itself
+			err := configurer.configureElements(&labelledEngine{engine, router_}, v.Elems, router_, v.Args)
+			if err != nil {
+				return err
+			}
+			configurer.logger.Info("Label configured: %s", v.Args)
 		}
 	}
 	return nil
 }
 
-func NewFluentConfigurer(logger Logger, inputFactoryRegistry InputFactoryRegistry, outputFactoryRegistry OutputFactoryRegistry, router *FluentRouter) *FluentConfigurer {
+func (configurer *FluentConfigurer) Configure(engine Engine, config *Config) error {
+	// labels are collected first so that they can be referred to before
+	// their definitions
+	for _, v := range config.Root.Elems {
+		if v.Name != "label" {
+			continue
+		}
+		if v.Args == "" {
+			return errors.New("<label> requires a name")
+		}
+		if _, ok := configurer.labels[v.Args]; ok {
+			return errors.New("Label already defined: " + v.Args)
+		}
+		configurer.labels[v.Args] = NewFluentRouter()
+	}
+	errorStream := engine.ErrorStream()
+	errorRouter, ok := configurer.labels[ErrorLabel]
+	if ok {
+		errorStream.SetPort(errorRouter)
+	}
+	// failures in the @ERROR label itself are not fed back to avoid loops
+	configurer.router.SetErrorStream(errorStream)
+	for name, router := range configurer.labels {
+		if name != ErrorLabel {
+			router.SetErrorStream(errorStream)
+		}
+	}
+	return configurer.configureElements(engine, config.Root.Elems, configurer.router, "")
+}
+
+func NewFluentConfigurer(logger Logger, inputFactoryRegistry InputFactoryRegistry, outputFactoryRegistry OutputFactoryRegistry, filterFactoryRegistry FilterFactoryRegistry, router *FluentRouter) *FluentConfigurer {
 	return &FluentConfigurer{
 		logger:                logger,
 		router:                router,
+		labels:                make(map[string]*FluentRouter),
 		inputFactoryRegistry:  inputFactoryRegistry,
 		outputFactoryRegistry: outputFactoryRegistry,
+		filterFactoryRegistry: filterFactoryRegistry,
 	}
 }
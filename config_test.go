@@ -56,4 +56,189 @@ func TestParseConfig(t *testing.T) {
 	}
 }
 
+type testConfigLogger struct{ t *testing.T }
+
+func (logger *testConfigLogger) Critical(format string, args ...interface{}) {
+	logger.t.Logf(format, args...)
+}
+func (logger *testConfigLogger) Error(format string, args ...interface{}) {
+	logger.t.Logf(format, args...)
+}
+func (logger *testConfigLogger) Warning(format string, args ...interface{}) {
+	logger.t.Logf(format, args...)
+}
+func (logger *testConfigLogger) Notice(format string, args ...interface{}) {
+	logger.t.Logf(format, args...)
+}
+func (logger *testConfigLogger) Info(format string, args ...interface{}) {
+	logger.t.Logf(format, args...)
+}
+func (logger *testConfigLogger) Debug(format string, args ...interface{}) {
+	logger.t.Logf(format, args...)
+}
+
+type testConfigEngine struct {
+	Engine
+	port        Port
+	errorStream *ErrorStream
+}
+
+func (engine *testConfigEngine) DefaultPort() Port           { return engine.port }
+func (engine *testConfigEngine) Launch(PluginInstance) error { return nil }
+func (engine *testConfigEngine) ErrorStream() *ErrorStream   { return engine.errorStream }
+
+type testConfigPlugin struct {
+	inputs  []*testConfigInput
+	outputs []*testConfigOutput
+}
+
+func (plugin *testConfigPlugin) Name() string                 { return "test" }
+func (plugin *testConfigPlugin) BindScorekeeper(*Scorekeeper) {}
+
+type testConfigInput struct{ port Port }
+
+func (input *testConfigInput) Run() error      { return nil }
+func (input *testConfigInput) Shutdown() error { return nil }
+func (input *testConfigInput) Factory() Plugin { return nil }
+func (input *testConfigInput) Port() Port      { return input.port }
+
+type testConfigOutput struct {
+	testRouterPort
+	args string
+	port Port
+}
+
+func (output *testConfigOutput) Run() error      { return nil }
+func (output *testConfigOutput) Shutdown() error { return nil }
+func (output *testConfigOutput) Factory() Plugin { return nil }
+
+func (plugin *testConfigPlugin) RegisterInputFactory(InputFactory) error { return nil }
+func (plugin *testConfigPlugin) LookupInputFactory(string) InputFactory {
+	return &testConfigInputFactory{plugin}
+}
+func (plugin *testConfigPlugin) RegisterOutputFactory(OutputFactory) error { return nil }
+func (plugin *testConfigPlugin) LookupOutputFactory(string) OutputFactory {
+	return &testConfigOutputFactory{plugin}
+}
+func (plugin *testConfigPlugin) RegisterFilterFactory(FilterFactory) error { return nil }
+func (plugin *testConfigPlugin) LookupFilterFactory(string) FilterFactory  { return nil }
+
+type testConfigInputFactory struct{ *testConfigPlugin }
+
+func (factory *testConfigInputFactory) New(engine Engine, config *ConfigElement) (Input, error) {
+	input := &testConfigInput{engine.DefaultPort()}
+	factory.inputs = append(factory.inputs, input)
+	return input, nil
+}
+
+type testConfigOutputFactory struct{ *testConfigPlugin }
+
+func (factory *testConfigOutputFactory) New(engine Engine, config *ConfigElement) (Output, error) {
+	output := &testConfigOutput{args: config.Args, port: engine.DefaultPort()}
+	factory.outputs = append(factory.outputs, output)
+	return output, nil
+}
+
+func TestFluentConfigurer_label(t *testing.T) {
+	const data = "<source>\n" +
+		"@type test\n" +
+		"@label @app\n" +
+		"</source>\n" +
+		"<source>\n" +
+		"type test\n" +
+		"</source>\n" +
+		"<match **>\n" +
+		"@type test\n" +
+		"</match>\n" +
+		"<label @app>\n" +
+		"<match app.**>\n" +
+		"@type test\n" +
+		"</match>\n" +
+		"</label>\n"
+	config, err := ParseConfig(myOpener(data), "test.cfg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Root.Elems[0].Type() != "test" || config.Root.Elems[0].Attrs["@label"] != "@app" {
+		t.FailNow()
+	}
+	router := NewFluentRouter()
+	plugin := &testConfigPlugin{}
+	configurer := NewFluentConfigurer(&testConfigLogger{t}, plugin, plugin, plugin, router)
+	err = configurer.Configure(&testConfigEngine{port: router, errorStream: NewErrorStream(&testConfigLogger{t})}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plugin.inputs) != 2 || len(plugin.outputs) != 2 {
+		t.FailNow()
+	}
+	if plugin.inputs[0].port != configurer.labels["@app"] || plugin.inputs[1].port != router {
+		t.Fail()
+	}
+	recordSets := []FluentRecordSet{{Tag: "app.a", Records: []TinyFluentRecord{{}}}}
+	plugin.inputs[0].port.Emit(recordSets)
+	if len(plugin.outputs[0].recordSets) != 0 || len(plugin.outputs[1].recordSets) != 1 {
+		t.Fail()
+	}
+	plugin.inputs[1].port.Emit(recordSets)
+	if len(plugin.outputs[0].recordSets) != 1 || len(plugin.outputs[1].recordSets) != 1 {
+		t.Fail()
+	}
+}
+
+func TestFluentConfigurer_emitInLabel(t *testing.T) {
+	const data = "<match **>\n" +
+		"@type test\n" +
+		"</match>\n" +
+		"<label @app>\n" +
+		"<match app.**>\n" +
+		"@type test\n" +
+		"</match>\n" +
+		"<match rewritten.**>\n" +
+		"@type test\n" +
+		"</match>\n" +
+		"</label>\n"
+	config, err := ParseConfig(myOpener(data), "test.cfg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := NewFluentRouter()
+	plugin := &testConfigPlugin{}
+	configurer := NewFluentConfigurer(&testConfigLogger{t}, plugin, plugin, plugin, router)
+	err = configurer.Configure(&testConfigEngine{port: router, errorStream: NewErrorStream(&testConfigLogger{t})}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plugin.outputs) != 3 {
+		t.FailNow()
+	}
+	if plugin.outputs[0].port != router || plugin.outputs[1].port != configurer.labels["@app"] {
+		t.Fail()
+	}
+	// records re-emitted by an output in the label (as rewrite_tag_filter
+	// does) stay in the label
+	recordSets := []FluentRecordSet{{Tag: "rewritten.a", Records: []TinyFluentRecord{{}}}}
+	plugin.outputs[1].port.Emit(recordSets)
+	if len(plugin.outputs[0].recordSets) != 0 || len(plugin.outputs[2].recordSets) != 1 {
+		t.Fail()
+	}
+}
+
+func TestFluentConfigurer_undefinedLabel(t *testing.T) {
+	const data = "<source>\n" +
+		"type test\n" +
+		"@label @undefined\n" +
+		"</source>\n"
+	config, err := ParseConfig(myOpener(data), "test.cfg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugin := &testConfigPlugin{}
+	router := NewFluentRouter()
+	err = NewFluentConfigurer(&testConfigLogger{t}, plugin, plugin, plugin, router).Configure(&testConfigEngine{port: router, errorStream: NewErrorStream(&testConfigLogger{t})}, config)
+	if err == nil {
+		t.Fail()
+	}
+}
+
 // vim: sts=4 sw=4 ts=4 noet
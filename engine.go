@@ -3,20 +3,22 @@ package ik
 import (
 	"github.com/moriyoshi/ik/task"
 	"math/rand"
+	"sync/atomic"
 	"time"
 )
 
 type recurringTaskDaemon struct {
-	engine   *engineImpl
-	shutdown bool
+	engine        *engineImpl
+	shutdown      bool
+	runnerStopped int32
 }
 
 func (daemon *recurringTaskDaemon) Run() error {
 	daemon.engine.recurringTaskScheduler.ProcessEvent()
-	if daemon.shutdown {
+	// keep serving until the runner stops as it may be waiting for a reply
+	if daemon.shutdown && atomic.LoadInt32(&daemon.runnerStopped) != 0 {
 		return nil
 	}
-	time.Sleep(1000000000)
 	return Continue
 }
 
@@ -26,13 +28,47 @@ func (daemon *recurringTaskDaemon) Shutdown() error {
 	return nil
 }
 
+// recurringTaskRunner runs the tasks registered to the scheduler when
+// they come due.
+type recurringTaskRunner struct {
+	daemon   *recurringTaskDaemon
+	shutdown bool
+}
+
+func (runner *recurringTaskRunner) Run() error {
+	if runner.shutdown {
+		atomic.StoreInt32(&runner.daemon.runnerStopped, 1)
+		runner.daemon.engine.recurringTaskScheduler.NoOp()
+		return nil
+	}
+	remaining, _, err := runner.daemon.engine.recurringTaskScheduler.RunNext()
+	if err != nil {
+		runner.daemon.engine.logger.Error("%s", err.Error())
+	}
+	// wake up at least once a second to pick up newly registered tasks
+	if remaining > 1000000000 {
+		remaining = 1000000000
+	}
+	if remaining > 0 {
+		time.Sleep(remaining)
+	}
+	return Continue
+}
+
+func (runner *recurringTaskRunner) Shutdown() error {
+	runner.shutdown = true
+	return nil
+}
+
 type engineImpl struct {
 	logger                   Logger
 	opener                   Opener
 	lineParserPluginRegistry LineParserPluginRegistry
+	outputFactoryRegistry    OutputFactoryRegistry
 	randSource               rand.Source
 	scorekeeper              *Scorekeeper
 	defaultPort              Port
+	errorStream              *ErrorStream
 	spawner                  *Spawner
 	pluginInstances          []PluginInstance
 	taskRunner               task.TaskRunner
@@ -51,6 +87,10 @@ func (engine *engineImpl) LineParserPluginRegistry() LineParserPluginRegistry {
 	return engine.lineParserPluginRegistry
 }
 
+func (engine *engineImpl) OutputFactoryRegistry() OutputFactoryRegistry {
+	return engine.outputFactoryRegistry
+}
+
 func (engine *engineImpl) RandSource() rand.Source {
 	return engine.randSource
 }
@@ -67,6 +107,10 @@ func (engine *engineImpl) DefaultPort() Port {
 	return engine.defaultPort
 }
 
+func (engine *engineImpl) ErrorStream() *ErrorStream {
+	return engine.errorStream
+}
+
 func (engine *engineImpl) Dispose() error {
 	spawnees, err := engine.spawner.GetRunningSpawnees()
 	if err != nil {
@@ -114,7 +158,7 @@ func (engine *engineImpl) Start() error {
 	return engine.spawner.PollMultiple(spawnees)
 }
 
-func NewEngine(logger Logger, opener Opener, lineParserPluginRegistry LineParserPluginRegistry, scorekeeper *Scorekeeper, defaultPort Port) *engineImpl {
+func NewEngine(logger Logger, opener Opener, lineParserPluginRegistry LineParserPluginRegistry, outputFactoryRegistry OutputFactoryRegistry, scorekeeper *Scorekeeper, defaultPort Port) *engineImpl {
 	taskRunner := &task.SimpleTaskRunner{}
 	recurringTaskScheduler := task.NewRecurringTaskScheduler(
 		func() time.Time { return time.Now() },
@@ -124,14 +168,18 @@ func NewEngine(logger Logger, opener Opener, lineParserPluginRegistry LineParser
 		logger: logger,
 		opener: opener,
 		lineParserPluginRegistry: lineParserPluginRegistry,
+		outputFactoryRegistry:    outputFactoryRegistry,
 		randSource:               NewRandSourceWithTimestampSeed(),
 		scorekeeper:              scorekeeper,
 		defaultPort:              defaultPort,
+		errorStream:              NewErrorStream(logger),
 		spawner:                  NewSpawner(),
 		pluginInstances:          make([]PluginInstance, 0),
 		taskRunner:               taskRunner,
 		recurringTaskScheduler:   recurringTaskScheduler,
 	}
-	engine.Spawn(&recurringTaskDaemon{engine, false})
+	daemon := &recurringTaskDaemon{engine, false, 0}
+	engine.Spawn(daemon)
+	engine.Spawn(&recurringTaskRunner{daemon, false})
 	return engine
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	fileid "github.com/moriyoshi/go-fileid"
+	"github.com/moriyoshi/ik/plugins"
+	"github.com/op/go-logging"
+	"os"
+	"strconv"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: %s POS_FILE list [PATH...]
+       %s POS_FILE set PATH POSITION|end
+       %s POS_FILE reset PATH...
+
+Inspects and edits the position file of in_tail.  Stop ik beforehand, as
+the changes are otherwise overwritten.
+
+  list   shows the positions of the given paths, or of all the paths
+  set    sets the position of the path; "end" means the current size
+  reset  forgets the paths so that they are regarded as new files
+`, os.Args[0], os.Args[0], os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(255)
+}
+
+func exitWithMessage(message string, exitStatus int) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], message)
+	os.Exit(exitStatus)
+}
+
+func exitWithError(err error, exitStatus int) {
+	exitWithMessage(err.Error(), exitStatus)
+}
+
+func list(positionFile *plugins.TailPositionFile, paths []string) error {
+	if len(paths) == 0 {
+		paths = positionFile.Paths()
+	} else {
+		known := make(map[string]struct{})
+		for _, path := range positionFile.Paths() {
+			known[path] = struct{}{}
+		}
+		for _, path := range paths {
+			if _, ok := known[path]; !ok {
+				return errors.New("no such entry: " + path)
+			}
+		}
+	}
+	for _, path := range paths {
+		tailFileInfo := positionFile.Get(path)
+		position := tailFileInfo.GetPosition()
+		tailFileInfo.Dispose()
+		if position == plugins.TailPositionCompleted {
+			fmt.Printf("%s\tcompleted\n", path)
+		} else {
+			fmt.Printf("%s\t%d\n", path, position)
+		}
+	}
+	return nil
+}
+
+func set(positionFile *plugins.TailPositionFile, path string, positionStr string) error {
+	var position int64
+	info, err := os.Stat(path)
+	if positionStr == "end" {
+		if err != nil {
+			return err
+		}
+		position = info.Size()
+	} else {
+		position, err = strconv.ParseInt(positionStr, 10, 64)
+		if err != nil || position < 0 {
+			return errors.New("invalid position: " + positionStr)
+		}
+	}
+	tailFileInfo := positionFile.Get(path)
+	defer tailFileInfo.Dispose()
+	id, err := fileid.GetFileId(path, true)
+	if err == nil {
+		tailFileInfo.SetFileId(id)
+	}
+	tailFileInfo.SetPosition(position)
+	return tailFileInfo.Save()
+}
+
+func reset(positionFile *plugins.TailPositionFile, paths []string) error {
+	for _, path := range paths {
+		removed, err := positionFile.Remove(path)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			return errors.New("no such entry: " + path)
+		}
+	}
+	return nil
+}
+
+func main() {
+	var help bool
+	flag.BoolVar(&help, "h", false, "show help")
+	flag.Parse()
+	args := flag.Args()
+	if help || len(args) < 2 {
+		usage()
+	}
+
+	positionFilePath := args[0]
+	if _, err := os.Stat(positionFilePath); err != nil {
+		exitWithError(err, 1)
+	}
+	positionFile, err := plugins.OpenTailPositionFile(logging.MustGetLogger("ik-posfile"), positionFilePath)
+	if err != nil {
+		exitWithError(err, 1)
+	}
+	switch args[1] {
+	case "list":
+		err = list(positionFile, args[2:])
+	case "set":
+		if len(args) != 4 {
+			usage()
+		}
+		err = set(positionFile, args[2], args[3])
+	case "reset":
+		if len(args) < 3 {
+			usage()
+		}
+		err = reset(positionFile, args[2:])
+	default:
+		usage()
+	}
+	// the changes, if any, are written out on disposal
+	err_ := positionFile.Dispose()
+	if err == nil {
+		err = err_
+	}
+	if err != nil {
+		exitWithError(err, 1)
+	}
+}
@@ -22,7 +22,7 @@ func configureScoreboards(logger ik.Logger, registry *MultiFactoryRegistry, engi
 	for _, v := range config.Root.Elems {
 		switch v.Name {
 		case "scoreboard":
-			type_ := v.Attrs["type"]
+			type_ := v.Type()
 			scoreboardFactory := registry.LookupScoreboardFactory(type_)
 			if scoreboardFactory == nil {
 				return errors.New("Could not find scoreboard factory: " + type_)
@@ -72,6 +72,8 @@ func main() {
 			registry.RegisterInputFactory(plugin)
 		case ik.OutputFactory:
 			registry.RegisterOutputFactory(plugin)
+		case ik.FilterFactory:
+			registry.RegisterFilterFactory(plugin)
 		}
 	}
 
@@ -82,7 +84,7 @@ func main() {
 	registry.RegisterScoreboardFactory(&HTMLHTTPScoreboardFactory{})
 
 	router := ik.NewFluentRouter()
-	engine := ik.NewEngine(logger, opener, registry, scorekeeper, router)
+	engine := ik.NewEngine(logger, opener, registry, registry, scorekeeper, router)
 	defer func() {
 		err := engine.Dispose()
 		if err != nil {
@@ -90,7 +92,7 @@ func main() {
 		}
 	}()
 
-	err = ik.NewFluentConfigurer(logger, registry, registry, router).Configure(engine, config)
+	err = ik.NewFluentConfigurer(logger, registry, registry, registry, router).Configure(engine, config)
 	if err != nil {
 		println(err.Error())
 		return
@@ -10,6 +10,7 @@ type MultiFactoryRegistry struct {
 	scorekeeper                *ik.Scorekeeper
 	inputFactories             map[string]ik.InputFactory
 	outputFactories            map[string]ik.OutputFactory
+	filterFactories            map[string]ik.FilterFactory
 	scoreboardFactories        map[string]ik.ScoreboardFactory
 	lineParserPlugins          map[string]ik.LineParserPlugin
 	lineParserFactoryFactories map[string]ik.LineParserFactoryFactory
@@ -54,6 +55,25 @@ func (registry *MultiFactoryRegistry) LookupOutputFactory(name string) ik.Output
 	return factory
 }
 
+func (registry *MultiFactoryRegistry) RegisterFilterFactory(factory ik.FilterFactory) error {
+	_, alreadyExists := registry.filterFactories[factory.Name()]
+	if alreadyExists {
+		return errors.New(fmt.Sprintf("FilterFactory named %s already registered", factory.Name()))
+	}
+	registry.filterFactories[factory.Name()] = factory
+	registry.plugins = append(registry.plugins, factory)
+	factory.BindScorekeeper(registry.scorekeeper)
+	return nil
+}
+
+func (registry *MultiFactoryRegistry) LookupFilterFactory(name string) ik.FilterFactory {
+	factory, ok := registry.filterFactories[name]
+	if !ok {
+		return nil
+	}
+	return factory
+}
+
 func (registry *MultiFactoryRegistry) RegisterScoreboardFactory(factory ik.ScoreboardFactory) error {
 	_, alreadyExists := registry.scoreboardFactories[factory.Name()]
 	if alreadyExists {
@@ -112,6 +132,7 @@ func NewMultiFactoryRegistry(scorekeeper *ik.Scorekeeper) *MultiFactoryRegistry
 		scorekeeper:                scorekeeper,
 		inputFactories:             make(map[string]ik.InputFactory),
 		outputFactories:            make(map[string]ik.OutputFactory),
+		filterFactories:            make(map[string]ik.FilterFactory),
 		scoreboardFactories:        make(map[string]ik.ScoreboardFactory),
 		lineParserPlugins:          make(map[string]ik.LineParserPlugin),
 		lineParserFactoryFactories: make(map[string]ik.LineParserFactoryFactory),
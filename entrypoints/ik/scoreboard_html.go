@@ -75,6 +75,7 @@ h1 {
 
 .pluginName.input:before,
 .pluginName.output:before,
+.pluginName.filter:before,
 .pluginName.scoreboard:before {
 	display: inline-block;
 	border-radius: 4px;
@@ -95,6 +96,11 @@ h1 {
 	background-color: #d30;
 }
 
+.pluginName.filter:before {
+	content: "filter";
+	background-color: #36c;
+}
+
 .pluginName.scoreboard:before {
 	content: "scoreboard";
 	background-color: #da4;
@@ -120,6 +126,12 @@ h1 {
 {{.Name}}
 {{end}}
 </dd>
+<dt>Filter Plugins</dt>
+<dd>
+{{range .FilterPlugins}}
+{{.Name}}
+{{end}}
+</dd>
 <dt>Scoreboard Plugins</dt>
 <dd>
 {{range .ScoreboardPlugins}}
@@ -207,6 +219,7 @@ type pluginInstanceStatus struct {
 type viewModel struct {
 	InputPlugins                    []ik.InputFactory
 	OutputPlugins                   []ik.OutputFactory
+	FilterPlugins                   []ik.FilterFactory
 	ScoreboardPlugins               []ik.ScoreboardFactory
 	Plugins                         []ik.Plugin
 	PluginInstanceStatusesPerPlugin map[ik.Plugin][]pluginInstanceStatus
@@ -270,6 +283,8 @@ func renderPluginType(plugin ik.Plugin) string {
 		return "input"
 	case ik.OutputFactory:
 		return "output"
+	case ik.FilterFactory:
+		return "filter"
 	case ik.ScoreboardFactory:
 		return "scoreboard"
 	default:
@@ -317,6 +332,7 @@ func (scoreboard *HTMLHTTPScoreboard) ServeHTTP(resp http.ResponseWriter, req *h
 	plugins := scoreboard.registry.Plugins()
 	inputPlugins := make([]ik.InputFactory, 0)
 	outputPlugins := make([]ik.OutputFactory, 0)
+	filterPlugins := make([]ik.FilterFactory, 0)
 	scoreboardPlugins := make([]ik.ScoreboardFactory, 0)
 	for _, plugin := range plugins {
 		switch plugin_ := plugin.(type) {
@@ -324,6 +340,8 @@ func (scoreboard *HTMLHTTPScoreboard) ServeHTTP(resp http.ResponseWriter, req *h
 			inputPlugins = append(inputPlugins, plugin_)
 		case ik.OutputFactory:
 			outputPlugins = append(outputPlugins, plugin_)
+		case ik.FilterFactory:
+			filterPlugins = append(filterPlugins, plugin_)
 		case ik.ScoreboardFactory:
 			scoreboardPlugins = append(scoreboardPlugins, plugin_)
 		}
@@ -364,6 +382,7 @@ func (scoreboard *HTMLHTTPScoreboard) ServeHTTP(resp http.ResponseWriter, req *h
 	scoreboard.template.Execute(resp, viewModel{
 		InputPlugins:      inputPlugins,
 		OutputPlugins:     outputPlugins,
+		FilterPlugins:     filterPlugins,
 		ScoreboardPlugins: scoreboardPlugins,
 		Plugins:           plugins,
 		PluginInstanceStatusesPerPlugin: pluginInstanceStatusesPerPlugin,
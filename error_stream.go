@@ -0,0 +1,78 @@
+package ik
+
+import (
+	"time"
+)
+
+// ErrorLabel is the label that receives the records that failed in filters,
+// outputs or parsers.
+const ErrorLabel = "@ERROR"
+
+// ErrorStream re-emits the failed records to the router of the @ERROR
+// label, with the error message stored under "error".
+type ErrorStream struct {
+	logger Logger
+	port   Port
+}
+
+// ParseError is returned by LineParser.Feed when the line does not conform
+// to the format.
+type ParseError struct {
+	Line    string
+	Message string
+}
+
+func (err *ParseError) Error() string {
+	return err.Message + ": " + err.Line
+}
+
+func (stream *ErrorStream) SetPort(port Port) {
+	stream.port = port
+}
+
+func (stream *ErrorStream) Port() Port {
+	return stream.port
+}
+
+// EmitError passes the record sets to the @ERROR label.  The original error
+// is returned as is if no such label is configured.
+func (stream *ErrorStream) EmitError(recordSets []FluentRecordSet, err error) error {
+	if stream.port == nil {
+		return err
+	}
+	message := err.Error()
+	errorRecordSets := make([]FluentRecordSet, len(recordSets))
+	for i, recordSet := range recordSets {
+		records := make([]TinyFluentRecord, len(recordSet.Records))
+		for j, record := range recordSet.Records {
+			data := make(map[string]interface{}, len(record.Data)+1)
+			for k, v := range record.Data {
+				data[k] = v
+			}
+			data["error"] = message
+			records[j] = TinyFluentRecord{Timestamp: record.Timestamp, Data: data}
+		}
+		errorRecordSets[i] = FluentRecordSet{Tag: recordSet.Tag, Records: records}
+	}
+	err_ := stream.port.Emit(errorRecordSets)
+	if err_ != nil {
+		stream.logger.Error("Failed to emit records to %s: %s (original error: %s)", ErrorLabel, err_.Error(), message)
+		return err_
+	}
+	return nil
+}
+
+// EmitErrorLine passes a line that could not be parsed to the @ERROR label
+// as a record having the line under "message".
+func (stream *ErrorStream) EmitErrorLine(tag string, line string, err error) error {
+	return stream.EmitError([]FluentRecordSet{
+		{
+			Tag:     tag,
+			Records: []TinyFluentRecord{{Timestamp: time.Now(), Data: map[string]interface{}{"message": line}}},
+		},
+	}, err)
+}
+
+func NewErrorStream(logger Logger) *ErrorStream {
+	return &ErrorStream{logger: logger}
+}
@@ -1,18 +1,87 @@
 package ik
 
+// CopyMode tells how the records are duplicated for each port of a Fanout.
+type CopyMode int
+
+const (
+	NoCopy      = CopyMode(0) // every port shares the same records
+	ShallowCopy = CopyMode(1) // every port gets its own top-level maps
+	DeepCopy    = CopyMode(2) // every port gets its own copy of everything
+)
+
 type Fanout struct {
-	ports []Port
+	ports    []Port
+	CopyMode CopyMode
 }
 
 func (fanout *Fanout) AddPort(port Port) {
 	fanout.ports = append(fanout.ports, port)
 }
 
+func deepCopyValue(value interface{}) interface{} {
+	switch value_ := value.(type) {
+	case map[string]interface{}:
+		retval := make(map[string]interface{}, len(value_))
+		for k, v := range value_ {
+			retval[k] = deepCopyValue(v)
+		}
+		return retval
+	case map[interface{}]interface{}:
+		retval := make(map[interface{}]interface{}, len(value_))
+		for k, v := range value_ {
+			retval[k] = deepCopyValue(v)
+		}
+		return retval
+	case []interface{}:
+		retval := make([]interface{}, len(value_))
+		for i, v := range value_ {
+			retval[i] = deepCopyValue(v)
+		}
+		return retval
+	case []byte:
+		retval := make([]byte, len(value_))
+		copy(retval, value_)
+		return retval
+	default:
+		return value
+	}
+}
+
+// CopyRecordSets duplicates the record sets according to the mode.
+func CopyRecordSets(recordSets []FluentRecordSet, mode CopyMode) []FluentRecordSet {
+	if mode == NoCopy {
+		return recordSets
+	}
+	retval := make([]FluentRecordSet, len(recordSets))
+	for i, recordSet := range recordSets {
+		records := make([]TinyFluentRecord, len(recordSet.Records))
+		for j, record := range recordSet.Records {
+			var data map[string]interface{}
+			if mode == DeepCopy {
+				data = deepCopyValue(record.Data).(map[string]interface{})
+			} else {
+				data = make(map[string]interface{}, len(record.Data))
+				for k, v := range record.Data {
+					data[k] = v
+				}
+			}
+			records[j] = TinyFluentRecord{Timestamp: record.Timestamp, Data: data}
+		}
+		retval[i] = FluentRecordSet{Tag: recordSet.Tag, Records: records, Hops: recordSet.Hops}
+	}
+	return retval
+}
+
 func (fanout *Fanout) Emit(recordSets []FluentRecordSet) error {
-	for _, port := range fanout.ports {
-		err := port.Emit(recordSets)
+	for i, port := range fanout.ports {
+		recordSets_ := recordSets
+		// the last port can take the original
+		if i < len(fanout.ports)-1 {
+			recordSets_ = CopyRecordSets(recordSets, fanout.CopyMode)
+		}
+		err := port.Emit(recordSets_)
 		if err != nil {
-			panic("MUST DO SOMETHING GOOD") // TODO
+			return err
 		}
 	}
 	return nil
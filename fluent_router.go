@@ -2,15 +2,32 @@ package ik
 
 import (
 	"regexp"
+	"strings"
+	"sync"
 )
 
+// maximum number of the tags whose destination is cached; the cache is
+// cleared once it gets full so that the tags containing e.g. hostnames
+// don't make it grow without bounds
+const fluentRouterCacheSize = 1024
+
 type fluentRouterRule struct {
-	re   *regexp.Regexp
-	port Port
+	patterns []string
+	re       *regexp.Regexp
+	port     Port
+}
+
+type fluentRouterFilter struct {
+	re     *regexp.Regexp
+	filter Filter
 }
 
 type FluentRouter struct {
-	rules []*fluentRouterRule
+	rules       []*fluentRouterRule
+	filters     []*fluentRouterFilter
+	cache       map[string]Port
+	mtx         sync.Mutex
+	errorStream *ErrorStream
 }
 
 type PatternError struct {
@@ -107,48 +124,227 @@ func BuildRegexpFromGlobPattern(pattern string) (string, error) {
 	return "^" + chunk + "$", nil
 }
 
-func (router *FluentRouter) AddRule(pattern string, port Port) error {
-	chunk, err := BuildRegexpFromGlobPattern(pattern)
+// splitPatterns splits the argument of <match> or <filter> into patterns.
+func splitPatterns(pattern string) []string {
+	patterns := strings.Fields(pattern)
+	if len(patterns) == 0 {
+		return []string{"**"}
+	}
+	return patterns
+}
+
+func buildRegexpFromGlobPatterns(patterns []string) (*regexp.Regexp, error) {
+	chunks := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		chunk, err := BuildRegexpFromGlobPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		chunks[i] = "(?:" + chunk + ")"
+	}
+	return regexp.Compile(strings.Join(chunks, "|"))
+}
+
+// expandBraces returns the tags denoted by the pattern, or nil if the
+// pattern contains wildcards.
+func expandBraces(pattern string) []string {
+	if strings.ContainsAny(pattern, "*\\") {
+		return nil
+	}
+	start := strings.IndexByte(pattern, '{')
+	if start < 0 {
+		return []string{pattern}
+	}
+	depth := 0
+	alternatives := make([]string, 0)
+	lastPos := start + 1
+	for i := start; i < len(pattern); i += 1 {
+		switch pattern[i] {
+		case '{':
+			depth += 1
+		case ',':
+			if depth == 1 {
+				alternatives = append(alternatives, pattern[lastPos:i])
+				lastPos = i + 1
+			}
+		case '}':
+			depth -= 1
+			if depth == 0 {
+				alternatives = append(alternatives, pattern[lastPos:i])
+				retval := make([]string, 0)
+				for _, alternative := range alternatives {
+					for _, tag := range expandBraces(pattern[0:start] + alternative + pattern[i+1:]) {
+						retval = append(retval, tag)
+					}
+				}
+				return retval
+			}
+		}
+	}
+	return nil
+}
+
+// isShadowed tells if every tag matched by the pattern is already caught by
+// the rules that precede it.
+func (router *FluentRouter) isShadowed(pattern string) bool {
+	for _, rule := range router.rules {
+		for _, pattern_ := range rule.patterns {
+			if pattern_ == pattern || pattern_ == "**" {
+				return true
+			}
+		}
+	}
+	tags := expandBraces(pattern)
+	if tags == nil {
+		return false
+	}
+outer:
+	for _, tag := range tags {
+		for _, rule := range router.rules {
+			if rule.re.MatchString(tag) {
+				continue outer
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// AddRule appends a rule that routes the record sets whose tag matches one
+// of the whitespace-separated patterns to the port.  As with fluentd, only
+// the first matching rule takes effect.  The returned flag tells if the
+// rule can never be reached because of the rules that precede it.
+func (router *FluentRouter) AddRule(pattern string, port Port) (bool, error) {
+	patterns := splitPatterns(pattern)
+	re, err := buildRegexpFromGlobPatterns(patterns)
 	if err != nil {
-		return err
+		return false, err
+	}
+	unreachable := true
+	for _, pattern_ := range patterns {
+		if !router.isShadowed(pattern_) {
+			unreachable = false
+			break
+		}
 	}
-	re, err := regexp.Compile(chunk)
+	router.mtx.Lock()
+	defer router.mtx.Unlock()
+	router.rules = append(router.rules, &fluentRouterRule{patterns, re, port})
+	router.cache = make(map[string]Port)
+	return unreachable, nil
+}
+
+// Resolve returns the port that the record sets with the tag are routed to,
+// or nil if no rule matches.
+func (router *FluentRouter) Resolve(tag string) Port {
+	router.mtx.Lock()
+	defer router.mtx.Unlock()
+	port, ok := router.cache[tag]
+	if ok {
+		return port
+	}
+	for _, rule := range router.rules {
+		if rule.re.MatchString(tag) {
+			port = rule.port
+			break
+		}
+	}
+	if len(router.cache) >= fluentRouterCacheSize {
+		router.cache = make(map[string]Port)
+	}
+	router.cache[tag] = port
+	return port
+}
+
+// AddFilter registers a filter that is applied to the record sets whose tag
+// matches the pattern.  Filters are applied in the order of registration.
+func (router *FluentRouter) AddFilter(pattern string, filter Filter) error {
+	re, err := buildRegexpFromGlobPatterns(splitPatterns(pattern))
 	if err != nil {
 		return err
 	}
-	newRule := &fluentRouterRule{re, port}
-	router.rules = append(router.rules, newRule)
+	router.filters = append(router.filters, &fluentRouterFilter{re, filter})
 	return nil
 }
 
-func (router *FluentRouter) Emit(recordSets []FluentRecordSet) error {
-	recordSetsMap := make(map[Port][]FluentRecordSet)
-	for i := range recordSets {
-		recordSet := &recordSets[i]
-		for _, rule := range router.rules {
-			if rule.re.MatchString(recordSet.Tag) {
-				recordSetsForPort, ok := recordSetsMap[rule.port]
-				if !ok {
-					recordSetsForPort = make([]FluentRecordSet, 0)
-				} else {
-					lastRecordSets := &recordSetsForPort[len(recordSetsForPort)-1]
-					if &lastRecordSets.Records == &recordSet.Records {
-						continue
-					}
+// SetErrorStream makes the router pass the record sets that failed in
+// filters or outputs to the stream instead of returning the error.
+func (router *FluentRouter) SetErrorStream(errorStream *ErrorStream) {
+	router.errorStream = errorStream
+}
+
+func (router *FluentRouter) handleError(recordSets []FluentRecordSet, err error) error {
+	if router.errorStream == nil {
+		return err
+	}
+	return router.errorStream.EmitError(recordSets, err)
+}
+
+func (router *FluentRouter) applyFilters(recordSets []FluentRecordSet) ([]FluentRecordSet, error) {
+	for _, filter := range router.filters {
+		retval := make([]FluentRecordSet, 0, len(recordSets))
+		for _, recordSet := range recordSets {
+			if !filter.re.MatchString(recordSet.Tag) {
+				retval = append(retval, recordSet)
+				continue
+			}
+			filtered, err := filter.filter.Filter([]FluentRecordSet{recordSet})
+			if err != nil {
+				err = router.handleError([]FluentRecordSet{recordSet}, err)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			for _, filteredRecordSet := range filtered {
+				filteredRecordSet.Hops = recordSet.Hops
+				if len(filteredRecordSet.Records) > 0 {
+					retval = append(retval, filteredRecordSet)
 				}
-				recordSetsMap[rule.port] = append(recordSetsForPort, *recordSet)
 			}
 		}
+		recordSets = retval
 	}
-	for port, recordSets := range recordSetsMap {
-		err := port.Emit(recordSets)
+	return recordSets, nil
+}
+
+func (router *FluentRouter) Emit(recordSets []FluentRecordSet) error {
+	if len(router.filters) > 0 {
+		var err error
+		recordSets, err = router.applyFilters(recordSets)
 		if err != nil {
 			return err
 		}
 	}
+	ports := make([]Port, 0)
+	recordSetsMap := make(map[Port][]FluentRecordSet)
+	for _, recordSet := range recordSets {
+		port := router.Resolve(recordSet.Tag)
+		if port == nil {
+			continue
+		}
+		recordSetsForPort, ok := recordSetsMap[port]
+		if !ok {
+			ports = append(ports, port)
+		}
+		recordSetsMap[port] = append(recordSetsForPort, recordSet)
+	}
+	for _, port := range ports {
+		err := port.Emit(recordSetsMap[port])
+		if err != nil {
+			err = router.handleError(recordSetsMap[port], err)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
 func NewFluentRouter() *FluentRouter {
-	return &FluentRouter{make([]*fluentRouterRule, 0)}
+	return &FluentRouter{
+		rules:   make([]*fluentRouterRule, 0),
+		filters: make([]*fluentRouterFilter, 0),
+		cache:   make(map[string]Port),
+	}
 }
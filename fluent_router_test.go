@@ -1,7 +1,10 @@
 package ik
 
 import (
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 )
 
 func TestBuildRegexpFromGlobPattern_0(t *testing.T) {
@@ -54,3 +57,178 @@ func TestBuildRegexpFromGlobPattern_4(t *testing.T) {
 		t.Fail()
 	}
 }
+
+type testRouterPort struct {
+	recordSets []FluentRecordSet
+}
+
+func (port *testRouterPort) Emit(recordSets []FluentRecordSet) error {
+	port.recordSets = append(port.recordSets, recordSets...)
+	return nil
+}
+
+type testFailingPort struct{}
+
+func (port *testFailingPort) Emit(recordSets []FluentRecordSet) error {
+	return errors.New("failed")
+}
+
+type testFilter struct {
+	fn func(recordSet FluentRecordSet) FluentRecordSet
+}
+
+func (filter *testFilter) Filter(recordSets []FluentRecordSet) ([]FluentRecordSet, error) {
+	retval := make([]FluentRecordSet, 0, len(recordSets))
+	for _, recordSet := range recordSets {
+		retval = append(retval, filter.fn(recordSet))
+	}
+	return retval, nil
+}
+
+func (filter *testFilter) Run() error      { return nil }
+func (filter *testFilter) Shutdown() error { return nil }
+func (filter *testFilter) Factory() Plugin { return nil }
+
+func TestFluentRouter_filter(t *testing.T) {
+	router := NewFluentRouter()
+	port := &testRouterPort{}
+	router.AddRule("**", port)
+	// drops the record sets tagged "drop.*"
+	router.AddFilter("drop.*", &testFilter{func(recordSet FluentRecordSet) FluentRecordSet {
+		return FluentRecordSet{Tag: recordSet.Tag}
+	}})
+	// filters are applied in order; the second one sees the result of the first
+	router.AddFilter("keep.*", &testFilter{func(recordSet FluentRecordSet) FluentRecordSet {
+		return FluentRecordSet{Tag: "renamed", Records: recordSet.Records}
+	}})
+	router.AddFilter("renamed", &testFilter{func(recordSet FluentRecordSet) FluentRecordSet {
+		for _, record := range recordSet.Records {
+			record.Data["filtered"] = true
+		}
+		return recordSet
+	}})
+	records := func() []TinyFluentRecord {
+		return []TinyFluentRecord{{Timestamp: time.Unix(1, 0), Data: map[string]interface{}{"a": "b"}}}
+	}
+	err := router.Emit([]FluentRecordSet{
+		{Tag: "drop.a", Records: records()},
+		{Tag: "keep.a", Records: records()},
+		{Tag: "other", Records: records()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(port.recordSets) != 2 {
+		t.Logf("%#v", port.recordSets)
+		t.FailNow()
+	}
+	if port.recordSets[0].Tag != "renamed" || port.recordSets[0].Records[0].Data["filtered"] != true {
+		t.Fail()
+	}
+	if port.recordSets[1].Tag != "other" || len(port.recordSets[1].Records[0].Data) != 1 {
+		t.Fail()
+	}
+}
+
+func TestFluentRouter_firstMatch(t *testing.T) {
+	router := NewFluentRouter()
+	a := &testRouterPort{}
+	b := &testRouterPort{}
+	router.AddRule("a.*", a)
+	router.AddRule("a.b **", b)
+	err := router.Emit([]FluentRecordSet{
+		{Tag: "a.b", Records: []TinyFluentRecord{{Timestamp: time.Unix(1, 0)}}},
+		{Tag: "c", Records: []TinyFluentRecord{{Timestamp: time.Unix(1, 0)}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.recordSets) != 1 || a.recordSets[0].Tag != "a.b" {
+		t.Fail()
+	}
+	if len(b.recordSets) != 1 || b.recordSets[0].Tag != "c" {
+		t.Fail()
+	}
+	if router.cache["a.b"] != a || router.cache["c"] != b {
+		t.Fail()
+	}
+}
+
+func TestFluentRouter_cacheSize(t *testing.T) {
+	router := NewFluentRouter()
+	port := &testRouterPort{}
+	router.AddRule("**", port)
+	for i := 0; i < fluentRouterCacheSize*3; i += 1 {
+		if router.Resolve(fmt.Sprintf("tag.%d", i)) != port {
+			t.FailNow()
+		}
+		if len(router.cache) > fluentRouterCacheSize {
+			t.Logf("%d", len(router.cache))
+			t.FailNow()
+		}
+	}
+}
+
+func TestFluentRouter_unreachable(t *testing.T) {
+	router := NewFluentRouter()
+	port := &testRouterPort{}
+	for _, pattern := range []string{"a.*", "b.{c,d}"} {
+		unreachable, err := router.AddRule(pattern, port)
+		if err != nil || unreachable {
+			t.Fail()
+		}
+	}
+	for _, pattern := range []string{"a.*", "a.b", "{a,b}.c", "a.x b.d"} {
+		unreachable, _ := router.AddRule(pattern, port)
+		if !unreachable {
+			t.Log(pattern)
+			t.Fail()
+		}
+	}
+	for _, pattern := range []string{"a.b.c", "a.**", "b.e", "a.x c"} {
+		unreachable, _ := router.AddRule(pattern, port)
+		if unreachable {
+			t.Log(pattern)
+			t.Fail()
+		}
+	}
+	unreachable, _ := router.AddRule("**", port)
+	if unreachable {
+		t.Fail()
+	}
+	unreachable, _ = router.AddRule("x.y.z", port)
+	if !unreachable {
+		t.Fail()
+	}
+}
+
+func TestFluentRouter_errorStream(t *testing.T) {
+	router := NewFluentRouter()
+	router.AddRule("**", &testFailingPort{})
+	recordSets := []FluentRecordSet{
+		{Tag: "a", Records: []TinyFluentRecord{{Timestamp: time.Unix(1, 0), Data: map[string]interface{}{"a": "b"}}}},
+	}
+	errorStream := NewErrorStream(nil)
+	router.SetErrorStream(errorStream)
+	// the error is returned as is without the @ERROR label
+	if router.Emit(recordSets) == nil {
+		t.Fail()
+	}
+	errorPort := &testRouterPort{}
+	errorStream.SetPort(errorPort)
+	err := router.Emit(recordSets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errorPort.recordSets) != 1 || errorPort.recordSets[0].Tag != "a" {
+		t.FailNow()
+	}
+	data := errorPort.recordSets[0].Records[0].Data
+	if data["a"] != "b" || data["error"] != "failed" {
+		t.Fail()
+	}
+	// the original record is left intact
+	if _, ok := recordSets[0].Records[0].Data["error"]; ok {
+		t.Fail()
+	}
+}
@@ -5,22 +5,25 @@ import (
 	"io"
 	"math/rand"
 	"net/http"
+	"time"
 )
 
 type FluentRecord struct {
 	Tag       string
-	Timestamp uint64
+	Timestamp time.Time
 	Data      map[string]interface{}
 }
 
 type TinyFluentRecord struct {
-	Timestamp uint64
+	Timestamp time.Time
 	Data      map[string]interface{}
 }
 
 type FluentRecordSet struct {
 	Tag     string
 	Records []TinyFluentRecord
+	// number of times the records were re-emitted under another tag
+	Hops int
 }
 
 type Port interface {
@@ -47,6 +50,13 @@ type Output interface {
 	Port
 }
 
+// Filter transforms the record sets before they are dispatched to the
+// outputs.  Record sets that are absent from the result are dropped.
+type Filter interface {
+	PluginInstance
+	Filter(recordSets []FluentRecordSet) ([]FluentRecordSet, error)
+}
+
 type MarkupAttributes int
 
 const (
@@ -103,9 +113,11 @@ type Engine interface {
 	Logger() Logger
 	Opener() Opener
 	LineParserPluginRegistry() LineParserPluginRegistry
+	OutputFactoryRegistry() OutputFactoryRegistry
 	RandSource() rand.Source
 	Scorekeeper() *Scorekeeper
 	DefaultPort() Port
+	ErrorStream() *ErrorStream
 	Spawn(Spawnee) error
 	Launch(PluginInstance) error
 	SpawneeStatuses() ([]SpawneeStatus, error)
@@ -133,6 +145,16 @@ type OutputFactoryRegistry interface {
 	LookupOutputFactory(name string) OutputFactory
 }
 
+type FilterFactory interface {
+	Plugin
+	New(engine Engine, config *ConfigElement) (Filter, error)
+}
+
+type FilterFactoryRegistry interface {
+	RegisterFilterFactory(factory FilterFactory) error
+	LookupFilterFactory(name string) FilterFactory
+}
+
 type PluginRegistry interface {
 	Plugins() []Plugin
 }
@@ -101,6 +101,10 @@ func (wrapper *FileJournalChunkWrapper) GetNextChunk() ik.JournalChunk {
 		}
 		journal.chunks.mtx.Unlock()
 	}
+	if retval == nil {
+		// avoid returning a typed nil
+		return nil
+	}
 	return retval
 }
 
@@ -347,6 +351,19 @@ func (journal *FileJournal) newChunk() (*FileJournalChunk, error) {
 	return chunk, nil
 }
 
+// Rotate finalizes the current head chunk and starts a new one so that
+// the data written so far becomes ready for flushing.  It does nothing
+// if the head chunk is empty.
+func (journal *FileJournal) Rotate() error {
+	journal.mtx.Lock()
+	defer journal.mtx.Unlock()
+	if journal.writer == nil || journal.position == 0 {
+		return nil
+	}
+	_, err := journal.newChunk()
+	return err
+}
+
 func (journal *FileJournal) AddFlushListener(listener ik.JournalChunkListener) {
 	journal.mtx.Lock()
 	defer journal.mtx.Unlock()
@@ -401,6 +418,9 @@ func (journal *FileJournal) GetTailChunk() ik.JournalChunk {
 		}
 		journal.chunks.mtx.Unlock()
 	}
+	if retval == nil {
+		return nil
+	}
 	return retval
 }
 
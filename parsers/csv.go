@@ -0,0 +1,121 @@
+package parsers
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"strings"
+	"unicode/utf8"
+)
+
+// CSVLineParserPlugin provides "csv", which honors the quotation, and
+// "tsv", which simply splits the line by the delimiter.
+type CSVLineParserPlugin struct{}
+
+type CSVLineParserFactory struct {
+	plugin         *CSVLineParserPlugin
+	logger         ik.Logger
+	keys           []string
+	delimiter      string
+	quoted         bool
+	timeKeyHandler *timeKeyHandler
+}
+
+type CSVLineParser struct {
+	factory  *CSVLineParserFactory
+	receiver func(ik.FluentRecord) error
+}
+
+func (parser *CSVLineParser) split(line string) ([]string, error) {
+	factory := parser.factory
+	if !factory.quoted {
+		return strings.Split(line, factory.delimiter), nil
+	}
+	reader := csv.NewReader(strings.NewReader(line))
+	reader.Comma, _ = utf8.DecodeRuneInString(factory.delimiter)
+	reader.FieldsPerRecord = -1
+	return reader.Read()
+}
+
+func (parser *CSVLineParser) Feed(line string) error {
+	factory := parser.factory
+	values, err := parser.split(line)
+	if err != nil {
+		return &ik.ParseError{Line: line, Message: "Invalid CSV (" + err.Error() + ")"}
+	}
+	if len(values) != len(factory.keys) {
+		return &ik.ParseError{Line: line, Message: fmt.Sprintf("%d fields found where %d are expected", len(values), len(factory.keys))}
+	}
+	data := make(map[string]interface{}, len(values))
+	for i, value := range values {
+		data[factory.keys[i]] = value
+	}
+	timestamp, err := factory.timeKeyHandler.Extract(data)
+	if err != nil {
+		return &ik.ParseError{Line: line, Message: err.Error()}
+	}
+	return parser.receiver(ik.FluentRecord{
+		Tag:       "",
+		Timestamp: timestamp,
+		Data:      data,
+	})
+}
+
+func (*CSVLineParserPlugin) Name() string {
+	return "csv"
+}
+
+func (factory *CSVLineParserFactory) New(receiver func(ik.FluentRecord) error) (ik.LineParser, error) {
+	return &CSVLineParser{
+		factory:  factory,
+		receiver: receiver,
+	}, nil
+}
+
+func (plugin *CSVLineParserPlugin) OnRegistering(visitor func(name string, factoryFactory ik.LineParserFactoryFactory) error) error {
+	err := visitor("csv", func(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
+		return plugin.New(engine, config, ",", true)
+	})
+	if err != nil {
+		return err
+	}
+	return visitor("tsv", func(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
+		return plugin.New(engine, config, "\t", false)
+	})
+}
+
+func (plugin *CSVLineParserPlugin) New(engine ik.Engine, config *ik.ConfigElement, defaultDelimiter string, quoted bool) (ik.LineParserFactory, error) {
+	keysStr, ok := config.Attrs["keys"]
+	if !ok {
+		return nil, errors.New("Required attribute `keys' not found")
+	}
+	keys := make([]string, 0)
+	for _, key := range strings.Split(keysStr, ",") {
+		keys = append(keys, strings.TrimSpace(key))
+	}
+	delimiter, err := delimiterAttr(config, "delimiter", defaultDelimiter)
+	if err != nil {
+		return nil, err
+	}
+	if quoted {
+		r, size := utf8.DecodeRuneInString(delimiter)
+		if size != len(delimiter) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+			return nil, errors.New("delimiter of csv must be a single character other than quotes and line breaks")
+		}
+	}
+	timeKeyHandler, err := newTimeKeyHandler(config, "time")
+	if err != nil {
+		return nil, err
+	}
+	return &CSVLineParserFactory{
+		plugin:         plugin,
+		logger:         engine.Logger(),
+		keys:           keys,
+		delimiter:      delimiter,
+		quoted:         quoted,
+		timeKeyHandler: timeKeyHandler,
+	}, nil
+}
+
+var _ = AddPlugin(&CSVLineParserPlugin{})
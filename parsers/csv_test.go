@@ -0,0 +1,56 @@
+package parsers
+
+import (
+	"github.com/moriyoshi/ik"
+	"testing"
+	"time"
+)
+
+func TestCSVLineParser(t *testing.T) {
+	records, err := parseTestLine(t, &CSVLineParserPlugin{}, "csv", map[string]string{
+		"keys":        "time,host,message",
+		"time_format": "%Y-%m-%d %H:%M:%S",
+	}, `2014-05-13 16:53:20,127.0.0.1,"say ""hello"", world"`)
+	if err != nil || len(records) != 1 {
+		t.Fatal(err)
+	}
+	if !records[0].Timestamp.Equal(time.Date(2014, 5, 13, 16, 53, 20, 0, time.UTC)) {
+		t.Fail()
+	}
+	data := records[0].Data
+	if len(data) != 2 || data["host"] != "127.0.0.1" || data["message"] != `say "hello", world` {
+		t.Logf("%#v", data)
+		t.Fail()
+	}
+}
+
+func TestCSVLineParser_delimiter(t *testing.T) {
+	records, err := parseTestLine(t, &CSVLineParserPlugin{}, "csv", map[string]string{
+		"keys":      "a, b",
+		"delimiter": ";",
+	}, `1;"2;3"`)
+	if err != nil || len(records) != 1 || records[0].Data["a"] != "1" || records[0].Data["b"] != "2;3" {
+		t.Fail()
+	}
+	for _, line := range []string{`1;2;3`, `1;"2`} {
+		_, err = parseTestLine(t, &CSVLineParserPlugin{}, "csv", map[string]string{"keys": "a,b", "delimiter": ";"}, line)
+		if _, ok := err.(*ik.ParseError); !ok {
+			t.Log(line)
+			t.Fail()
+		}
+	}
+}
+
+func TestTSVLineParser(t *testing.T) {
+	records, err := parseTestLine(t, &CSVLineParserPlugin{}, "tsv", map[string]string{
+		"keys": "a,b,c",
+	}, "1\t\"2\t")
+	if err != nil || len(records) != 1 {
+		t.Fatal(err)
+	}
+	data := records[0].Data
+	if data["a"] != "1" || data["b"] != `"2` || data["c"] != "" {
+		t.Logf("%#v", data)
+		t.Fail()
+	}
+}
@@ -0,0 +1,103 @@
+package parsers
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/moriyoshi/ik"
+	"strconv"
+)
+
+type JSONLineParserPlugin struct{}
+
+type JSONLineParserFactory struct {
+	plugin         *JSONLineParserPlugin
+	logger         ik.Logger
+	timeKeyHandler *timeKeyHandler
+}
+
+type JSONLineParser struct {
+	factory  *JSONLineParserFactory
+	receiver func(ik.FluentRecord) error
+}
+
+// convertJSONNumbers turns json.Number into int64 if possible, or float64.
+func convertJSONNumbers(value interface{}) interface{} {
+	switch value_ := value.(type) {
+	case json.Number:
+		i, err := strconv.ParseInt(string(value_), 10, 64)
+		if err == nil {
+			return i
+		}
+		f, err := strconv.ParseFloat(string(value_), 64)
+		if err == nil {
+			return f
+		}
+		return string(value_)
+	case map[string]interface{}:
+		for k, v := range value_ {
+			value_[k] = convertJSONNumbers(v)
+		}
+	case []interface{}:
+		for i, v := range value_ {
+			value_[i] = convertJSONNumbers(v)
+		}
+	}
+	return value
+}
+
+func (parser *JSONLineParser) Feed(line string) error {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(line)))
+	decoder.UseNumber()
+	data := make(map[string]interface{})
+	err := decoder.Decode(&data)
+	if err != nil {
+		return &ik.ParseError{Line: line, Message: "Invalid JSON (" + err.Error() + ")"}
+	}
+	if data == nil {
+		return &ik.ParseError{Line: line, Message: "Not a JSON object"}
+	}
+	if decoder.More() {
+		return &ik.ParseError{Line: line, Message: "Trailing garbage after JSON"}
+	}
+	convertJSONNumbers(data)
+	timestamp, err := parser.factory.timeKeyHandler.Extract(data)
+	if err != nil {
+		return &ik.ParseError{Line: line, Message: err.Error()}
+	}
+	return parser.receiver(ik.FluentRecord{
+		Tag:       "",
+		Timestamp: timestamp,
+		Data:      data,
+	})
+}
+
+func (*JSONLineParserPlugin) Name() string {
+	return "json"
+}
+
+func (factory *JSONLineParserFactory) New(receiver func(ik.FluentRecord) error) (ik.LineParser, error) {
+	return &JSONLineParser{
+		factory:  factory,
+		receiver: receiver,
+	}, nil
+}
+
+func (plugin *JSONLineParserPlugin) OnRegistering(visitor func(name string, factoryFactory ik.LineParserFactoryFactory) error) error {
+	return visitor("json", func(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
+		return plugin.New(engine, config)
+	})
+}
+
+func (plugin *JSONLineParserPlugin) New(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
+	timeKeyHandler, err := newTimeKeyHandler(config, "time")
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLineParserFactory{
+		plugin:         plugin,
+		logger:         engine.Logger(),
+		timeKeyHandler: timeKeyHandler,
+	}, nil
+}
+
+var _ = AddPlugin(&JSONLineParserPlugin{})
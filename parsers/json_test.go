@@ -0,0 +1,117 @@
+package parsers
+
+import (
+	"github.com/moriyoshi/ik"
+	"testing"
+	"time"
+)
+
+type testLogger struct{ t *testing.T }
+
+func (logger *testLogger) Critical(format string, args ...interface{}) {
+	logger.t.Logf(format, args...)
+}
+func (logger *testLogger) Error(format string, args ...interface{})   { logger.t.Logf(format, args...) }
+func (logger *testLogger) Warning(format string, args ...interface{}) { logger.t.Logf(format, args...) }
+func (logger *testLogger) Notice(format string, args ...interface{})  { logger.t.Logf(format, args...) }
+func (logger *testLogger) Info(format string, args ...interface{})    { logger.t.Logf(format, args...) }
+func (logger *testLogger) Debug(format string, args ...interface{})   { logger.t.Logf(format, args...) }
+
+type testEngine struct {
+	ik.Engine
+	logger ik.Logger
+}
+
+func (engine *testEngine) Logger() ik.Logger { return engine.logger }
+
+// parseTestLine feeds a line to the parser that the plugin creates.
+func parseTestLine(t *testing.T, plugin ik.LineParserPlugin, name string, attrs map[string]string, line string) ([]ik.FluentRecord, error) {
+	var factoryFactory ik.LineParserFactoryFactory
+	plugin.OnRegistering(func(name_ string, factoryFactory_ ik.LineParserFactoryFactory) error {
+		if name_ == name {
+			factoryFactory = factoryFactory_
+		}
+		return nil
+	})
+	if factoryFactory == nil {
+		t.Fatalf("parser %s is not registered", name)
+	}
+	factory, err := factoryFactory(&testEngine{logger: &testLogger{t}}, &ik.ConfigElement{Attrs: attrs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make([]ik.FluentRecord, 0)
+	parser, err := factory.New(func(record ik.FluentRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = parser.Feed(line)
+	return records, err
+}
+
+func TestJSONLineParser(t *testing.T) {
+	records, err := parseTestLine(t, &JSONLineParserPlugin{}, "json", map[string]string{},
+		`{"time": 1400000000.5, "int": 1, "float": 1.5, "nested": {"a": [2, "b"]}, "s": "x"}`)
+	if err != nil || len(records) != 1 {
+		t.Fatal(err)
+	}
+	record := records[0]
+	if !record.Timestamp.Equal(time.Unix(1400000000, 500000000)) {
+		t.Log(record.Timestamp)
+		t.Fail()
+	}
+	data := record.Data
+	if _, ok := data["time"]; ok {
+		t.Fail()
+	}
+	if data["int"] != int64(1) || data["float"] != 1.5 || data["s"] != "x" {
+		t.Logf("%#v", data)
+		t.Fail()
+	}
+	nested := data["nested"].(map[string]interface{})["a"].([]interface{})
+	if nested[0] != int64(2) || nested[1] != "b" {
+		t.Fail()
+	}
+}
+
+func TestJSONLineParser_timeFormat(t *testing.T) {
+	records, err := parseTestLine(t, &JSONLineParserPlugin{}, "json", map[string]string{
+		"time_key":      "ts",
+		"time_format":   "%Y-%m-%d %H:%M:%S",
+		"keep_time_key": "true",
+	}, `{"ts": "2014-05-13 16:53:20"}`)
+	if err != nil || len(records) != 1 {
+		t.Fatal(err)
+	}
+	if !records[0].Timestamp.Equal(time.Date(2014, 5, 13, 16, 53, 20, 0, time.UTC)) {
+		t.Log(records[0].Timestamp)
+		t.Fail()
+	}
+	if records[0].Data["ts"] != "2014-05-13 16:53:20" {
+		t.Fail()
+	}
+}
+
+func TestJSONLineParser_noTime(t *testing.T) {
+	records, err := parseTestLine(t, &JSONLineParserPlugin{}, "json", map[string]string{}, `{"a": 1}`)
+	if err != nil || len(records) != 1 {
+		t.Fatal(err)
+	}
+	if !records[0].Timestamp.IsZero() {
+		t.Log(records[0].Timestamp)
+		t.Fail()
+	}
+}
+
+func TestJSONLineParser_invalid(t *testing.T) {
+	for _, line := range []string{`{"a": `, `[1, 2]`, `null`, `{} {}`, `{"time": "yesterday"}`} {
+		records, err := parseTestLine(t, &JSONLineParserPlugin{}, "json", map[string]string{}, line)
+		if _, ok := err.(*ik.ParseError); !ok || len(records) != 0 {
+			t.Log(line)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package parsers
+
+import (
+	"errors"
+	"github.com/moriyoshi/ik"
+	"strconv"
+	"strings"
+)
+
+type LTSVLineParserPlugin struct{}
+
+type LTSVLineParserFactory struct {
+	plugin         *LTSVLineParserPlugin
+	logger         ik.Logger
+	delimiter      string
+	labelDelimiter string
+	timeKeyHandler *timeKeyHandler
+}
+
+type LTSVLineParser struct {
+	factory  *LTSVLineParserFactory
+	receiver func(ik.FluentRecord) error
+}
+
+// unescapeDelimiter allows delimiters like "\t" to be written in the
+// configuration, either quoted or not.
+func unescapeDelimiter(s string) (string, error) {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return strconv.Unquote(s)
+	}
+	return strings.NewReplacer("\\t", "\t", "\\\\", "\\").Replace(s), nil
+}
+
+func delimiterAttr(config *ik.ConfigElement, name string, defaultValue string) (string, error) {
+	s, ok := config.Attrs[name]
+	if !ok {
+		return defaultValue, nil
+	}
+	retval, err := unescapeDelimiter(s)
+	if err != nil || retval == "" {
+		return "", errors.New("invalid " + name + ": " + s)
+	}
+	return retval, nil
+}
+
+func (parser *LTSVLineParser) Feed(line string) error {
+	factory := parser.factory
+	data := make(map[string]interface{})
+	for _, field := range strings.Split(line, factory.delimiter) {
+		if field == "" {
+			continue
+		}
+		pair := strings.SplitN(field, factory.labelDelimiter, 2)
+		if len(pair) != 2 {
+			return &ik.ParseError{Line: line, Message: "Field without label"}
+		}
+		data[pair[0]] = pair[1]
+	}
+	timestamp, err := factory.timeKeyHandler.Extract(data)
+	if err != nil {
+		return &ik.ParseError{Line: line, Message: err.Error()}
+	}
+	return parser.receiver(ik.FluentRecord{
+		Tag:       "",
+		Timestamp: timestamp,
+		Data:      data,
+	})
+}
+
+func (*LTSVLineParserPlugin) Name() string {
+	return "ltsv"
+}
+
+func (factory *LTSVLineParserFactory) New(receiver func(ik.FluentRecord) error) (ik.LineParser, error) {
+	return &LTSVLineParser{
+		factory:  factory,
+		receiver: receiver,
+	}, nil
+}
+
+func (plugin *LTSVLineParserPlugin) OnRegistering(visitor func(name string, factoryFactory ik.LineParserFactoryFactory) error) error {
+	return visitor("ltsv", func(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
+		return plugin.New(engine, config)
+	})
+}
+
+func (plugin *LTSVLineParserPlugin) New(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
+	delimiter, err := delimiterAttr(config, "delimiter", "\t")
+	if err != nil {
+		return nil, err
+	}
+	labelDelimiter, err := delimiterAttr(config, "label_delimiter", ":")
+	if err != nil {
+		return nil, err
+	}
+	timeKeyHandler, err := newTimeKeyHandler(config, "time")
+	if err != nil {
+		return nil, err
+	}
+	return &LTSVLineParserFactory{
+		plugin:         plugin,
+		logger:         engine.Logger(),
+		delimiter:      delimiter,
+		labelDelimiter: labelDelimiter,
+		timeKeyHandler: timeKeyHandler,
+	}, nil
+}
+
+var _ = AddPlugin(&LTSVLineParserPlugin{})
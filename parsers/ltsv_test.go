@@ -0,0 +1,37 @@
+package parsers
+
+import (
+	"github.com/moriyoshi/ik"
+	"testing"
+	"time"
+)
+
+func TestLTSVLineParser(t *testing.T) {
+	records, err := parseTestLine(t, &LTSVLineParserPlugin{}, "ltsv", map[string]string{},
+		"time:2014-05-13T16:53:20Z\thost:127.0.0.1\treq:GET /a:b HTTP/1.1\t")
+	if err != nil || len(records) != 1 {
+		t.Fatal(err)
+	}
+	if !records[0].Timestamp.Equal(time.Date(2014, 5, 13, 16, 53, 20, 0, time.UTC)) {
+		t.Fail()
+	}
+	data := records[0].Data
+	if len(data) != 2 || data["host"] != "127.0.0.1" || data["req"] != "GET /a:b HTTP/1.1" {
+		t.Logf("%#v", data)
+		t.Fail()
+	}
+}
+
+func TestLTSVLineParser_delimiter(t *testing.T) {
+	records, err := parseTestLine(t, &LTSVLineParserPlugin{}, "ltsv", map[string]string{
+		"delimiter":       ",",
+		"label_delimiter": "=",
+	}, "a=1,b=2")
+	if err != nil || len(records) != 1 || records[0].Data["a"] != "1" || records[0].Data["b"] != "2" {
+		t.Fail()
+	}
+	_, err = parseTestLine(t, &LTSVLineParserPlugin{}, "ltsv", map[string]string{}, "a:1\tb")
+	if _, ok := err.(*ik.ParseError); !ok {
+		t.Fail()
+	}
+}
@@ -0,0 +1,101 @@
+package parsers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"regexp"
+	"strings"
+)
+
+// MultilineMaxFormats is the maximum N of formatN.
+const MultilineMaxFormats = 20
+
+// MultilineLineParserPlugin parses the event that spans several lines,
+// which in_tail puts together with the help of format_firstline.  The
+// patterns given by format1 ... formatN are concatenated into a single
+// regular expression, in which `.' also matches line endings.
+type MultilineLineParserPlugin struct{}
+
+type MultilineLineParserFactory struct {
+	plugin         *MultilineLineParserPlugin
+	logger         ik.Logger
+	timeKeyHandler *timeKeyHandler
+	regex          *regexp.Regexp
+}
+
+type MultilineLineParser struct {
+	factory  *MultilineLineParserFactory
+	receiver func(ik.FluentRecord) error
+}
+
+func (parser *MultilineLineParser) Feed(lines string) error {
+	regex := parser.factory.regex
+	g := regex.FindStringSubmatchIndex(lines)
+	if g == nil {
+		return &ik.ParseError{Line: lines, Message: "Unparsed lines"}
+	}
+	data := make(map[string]interface{})
+	for i, name := range regex.SubexpNames() {
+		if name == "" || g[i*2] < 0 {
+			continue
+		}
+		data[name] = lines[g[i*2]:g[i*2+1]]
+	}
+	timestamp, err := parser.factory.timeKeyHandler.Extract(data)
+	if err != nil {
+		return &ik.ParseError{Line: lines, Message: err.Error()}
+	}
+	return parser.receiver(ik.FluentRecord{
+		Tag:       "",
+		Timestamp: timestamp,
+		Data:      data,
+	})
+}
+
+func (*MultilineLineParserPlugin) Name() string {
+	return "multiline"
+}
+
+func (factory *MultilineLineParserFactory) New(receiver func(ik.FluentRecord) error) (ik.LineParser, error) {
+	return &MultilineLineParser{
+		factory:  factory,
+		receiver: receiver,
+	}, nil
+}
+
+func (plugin *MultilineLineParserPlugin) OnRegistering(visitor func(name string, factoryFactory ik.LineParserFactoryFactory) error) error {
+	return visitor("multiline", func(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
+		return plugin.New(engine, config)
+	})
+}
+
+func (plugin *MultilineLineParserPlugin) New(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
+	patterns := make([]string, 0, 1)
+	for i := 1; i <= MultilineMaxFormats; i += 1 {
+		pattern, ok := config.Attrs[fmt.Sprintf("format%d", i)]
+		if !ok {
+			break
+		}
+		patterns = append(patterns, ik.StripRegexpDelimiters(pattern))
+	}
+	if len(patterns) == 0 {
+		return nil, errors.New("Required attribute `format1' not found")
+	}
+	regex, err := regexp.Compile("(?s)" + strings.Join(patterns, ""))
+	if err != nil {
+		return nil, err
+	}
+	timeKeyHandler, err := newTimeKeyHandler(config, "time")
+	if err != nil {
+		return nil, err
+	}
+	return &MultilineLineParserFactory{
+		plugin:         plugin,
+		logger:         engine.Logger(),
+		timeKeyHandler: timeKeyHandler,
+		regex:          regex,
+	}, nil
+}
+
+var _ = AddPlugin(&MultilineLineParserPlugin{})
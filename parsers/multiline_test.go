@@ -0,0 +1,33 @@
+package parsers
+
+import (
+	"github.com/moriyoshi/ik"
+	"testing"
+	"time"
+)
+
+func TestMultilineLineParser(t *testing.T) {
+	attrs := map[string]string{
+		"format1":     `/^(?P<time>\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z) (?P<level>\w+) /`,
+		"format2":     `/(?P<message>.*)/`,
+		"time_format": "%Y-%m-%dT%H:%M:%SZ",
+	}
+	records, err := parseTestLine(t, &MultilineLineParserPlugin{}, "multiline", attrs,
+		"2014-05-13T16:53:20Z ERROR java.lang.RuntimeException: oops\n\tat Foo.bar(Foo.java:1)")
+	if err != nil || len(records) != 1 {
+		t.Fatal(err)
+	}
+	if !records[0].Timestamp.Equal(time.Date(2014, 5, 13, 16, 53, 20, 0, time.UTC)) {
+		t.Log(records[0].Timestamp)
+		t.Fail()
+	}
+	data := records[0].Data
+	if len(data) != 2 || data["level"] != "ERROR" || data["message"] != "java.lang.RuntimeException: oops\n\tat Foo.bar(Foo.java:1)" {
+		t.Logf("%#v", data)
+		t.Fail()
+	}
+	_, err = parseTestLine(t, &MultilineLineParserPlugin{}, "multiline", attrs, "\tat Foo.bar(Foo.java:1)")
+	if _, ok := err.(*ik.ParseError); !ok {
+		t.Fail()
+	}
+}
@@ -0,0 +1,205 @@
+package parsers
+
+import (
+	"errors"
+	"github.com/moriyoshi/ik"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// presetFormat is a well-known log format that is parsed by a fixed
+// regular expression.
+type presetFormat struct {
+	re           *regexp.Regexp
+	parseTime    func(value string) (time.Time, error)
+	integerKeys  []string
+	nullableKeys []string // "-" in these fields means the absence of the value
+}
+
+// PresetLineParserPlugin provides the parsers for the formats that would
+// otherwise be written by hand with the regexp parser.
+type PresetLineParserPlugin struct{}
+
+type PresetLineParserFactory struct {
+	plugin  *PresetLineParserPlugin
+	logger  ik.Logger
+	formats []*presetFormat // the first matching one is used
+}
+
+type PresetLineParser struct {
+	factory  *PresetLineParserFactory
+	receiver func(ik.FluentRecord) error
+}
+
+const commonLogTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+func parseCommonLogTime(value string) (time.Time, error) {
+	return time.Parse(commonLogTimeLayout, value)
+}
+
+func parseApacheErrorTime(value string) (time.Time, error) {
+	// "Oct 11 14:32:52 2000" with the weekday stripped; 2.4 and later put
+	// microseconds after the seconds, which time.Parse accepts as well
+	return time.ParseInLocation("Jan _2 15:04:05 2006", value, time.Local)
+}
+
+// parseRFC3164Time complements the year, which is missing from the format,
+// assuming that the timestamp is not far in the future.
+func parseRFC3164Time(value string) (time.Time, error) {
+	return parseRFC3164TimeAt(value, time.Now())
+}
+
+// parseRFC3164TimeAt supplements the year, which is the latest one that
+// does not put the time after now.  The years without Feb 29 are skipped
+// for the leap day.
+func parseRFC3164TimeAt(value string, now time.Time) (time.Time, error) {
+	t, err := time.ParseInLocation(time.Stamp, value, time.Local)
+	if err != nil {
+		return t, err
+	}
+	for year := now.Year(); ; year -= 1 {
+		retval := time.Date(year, t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+		if retval.Day() == t.Day() && !retval.After(now) {
+			return retval, nil
+		}
+	}
+}
+
+func parseRFC5424Time(value string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+var (
+	apache2Format = &presetFormat{
+		re:           regexp.MustCompile(`^(?P<host>[^ ]*) [^ ]* (?P<user>[^ ]*) \[(?P<time>[^\]]*)\] "(?P<method>\S+)(?: +(?P<path>(?:[^\"]|\\.)*?)(?: +\S*)?)?" (?P<code>[^ ]*) (?P<size>[^ ]*)(?: "(?P<referer>(?:[^\"]|\\.)*)" "(?P<agent>(?:[^\"]|\\.)*)")?$`),
+		parseTime:    parseCommonLogTime,
+		integerKeys:  []string{"code", "size"},
+		nullableKeys: []string{"user", "size"},
+	}
+	apacheErrorFormat = &presetFormat{
+		re:        regexp.MustCompile(`^\[[^ ]* (?P<time>[^\]]*)\] \[(?P<level>[^\]]*)\](?: \[pid (?P<pid>[^\]]*)\])?(?: \[client (?P<client>[^\]]*)\])? (?P<message>.*)$`),
+		parseTime: parseApacheErrorTime,
+	}
+	nginxFormat = &presetFormat{
+		re:           regexp.MustCompile(`^(?P<remote>[^ ]*) (?P<host>[^ ]*) (?P<user>[^ ]*) \[(?P<time>[^\]]*)\] "(?P<method>\S+)(?: +(?P<path>[^\"]*?)(?: +\S*)?)?" (?P<code>[^ ]*) (?P<size>[^ ]*)(?: "(?P<referer>[^\"]*)" "(?P<agent>[^\"]*)"(?:\s+(?P<http_x_forwarded_for>[^ ]+))?)?$`),
+		parseTime:    parseCommonLogTime,
+		integerKeys:  []string{"code", "size"},
+		nullableKeys: []string{"host", "user", "size"},
+	}
+	rfc3164Format = &presetFormat{
+		re:          regexp.MustCompile(`^(?:<(?P<pri>[0-9]{1,3})>)?(?P<time>[A-Z][a-z]{2} [ 0-9][0-9] [0-9]{2}:[0-9]{2}:[0-9]{2}) (?P<host>[^ ]*) (?P<ident>[^ :\[]*)(?:\[(?P<pid>[0-9]+)\])?(?:[^\:]*\:)? *(?P<message>.*)$`),
+		parseTime:   parseRFC3164Time,
+		integerKeys: []string{"pri"},
+	}
+	rfc5424Format = &presetFormat{
+		re:           regexp.MustCompile(`^<(?P<pri>[0-9]{1,3})>1 (?P<time>[^ ]+) (?P<host>[^ ]+) (?P<ident>[^ ]+) (?P<pid>[^ ]+) (?P<msgid>[^ ]+) (?P<extradata>-|(?:\[(?:[^\]\\]|\\.)*\])+)(?: (?:\xef\xbb\xbf)?(?P<message>.*))?$`),
+		parseTime:    parseRFC5424Time,
+		integerKeys:  []string{"pri"},
+		nullableKeys: []string{"host", "ident", "pid", "msgid", "extradata"},
+	}
+)
+
+func (format *presetFormat) parse(line string) (ik.FluentRecord, bool, error) {
+	g := format.re.FindStringSubmatchIndex(line)
+	if g == nil {
+		return ik.FluentRecord{}, false, nil
+	}
+	data := make(map[string]interface{})
+	for i, name := range format.re.SubexpNames() {
+		// unmatched optional groups are left out
+		if name == "" || g[i*2] < 0 {
+			continue
+		}
+		data[name] = line[g[i*2]:g[i*2+1]]
+	}
+	for _, key := range format.nullableKeys {
+		if data[key] == "-" {
+			data[key] = nil
+		}
+	}
+	for _, key := range format.integerKeys {
+		value, ok := data[key].(string)
+		if !ok {
+			continue
+		}
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err == nil {
+			data[key] = i
+		}
+	}
+	timeStr, _ := data["time"].(string)
+	delete(data, "time")
+	timestamp, err := format.parseTime(timeStr)
+	if err != nil {
+		return ik.FluentRecord{}, true, errors.New("Invalid time: " + timeStr)
+	}
+	return ik.FluentRecord{Tag: "", Timestamp: timestamp, Data: data}, true, nil
+}
+
+func (parser *PresetLineParser) Feed(line string) error {
+	for _, format := range parser.factory.formats {
+		record, matched, err := format.parse(line)
+		if err != nil {
+			return &ik.ParseError{Line: line, Message: err.Error()}
+		}
+		if matched {
+			return parser.receiver(record)
+		}
+	}
+	return &ik.ParseError{Line: line, Message: "Unparsed line"}
+}
+
+func (*PresetLineParserPlugin) Name() string {
+	return "presets"
+}
+
+func (factory *PresetLineParserFactory) New(receiver func(ik.FluentRecord) error) (ik.LineParser, error) {
+	return &PresetLineParser{
+		factory:  factory,
+		receiver: receiver,
+	}, nil
+}
+
+func (plugin *PresetLineParserPlugin) OnRegistering(visitor func(name string, factoryFactory ik.LineParserFactoryFactory) error) error {
+	names := []string{"apache2", "apache_error", "nginx"}
+	formats := []*presetFormat{apache2Format, apacheErrorFormat, nginxFormat}
+	for i, name := range names {
+		format := formats[i]
+		err := visitor(name, func(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
+			return plugin.newPresetLineParserFactory(engine.Logger(), []*presetFormat{format})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return visitor("syslog", func(engine ik.Engine, config *ik.ConfigElement) (ik.LineParserFactory, error) {
+		messageFormat, ok := config.Attrs["message_format"]
+		if !ok {
+			messageFormat = "rfc3164"
+		}
+		var formats []*presetFormat
+		switch strings.ToLower(messageFormat) {
+		case "rfc3164":
+			formats = []*presetFormat{rfc3164Format}
+		case "rfc5424":
+			formats = []*presetFormat{rfc5424Format}
+		case "auto":
+			formats = []*presetFormat{rfc5424Format, rfc3164Format}
+		default:
+			return nil, errors.New("unknown message_format: " + messageFormat)
+		}
+		return plugin.newPresetLineParserFactory(engine.Logger(), formats)
+	})
+}
+
+func (plugin *PresetLineParserPlugin) newPresetLineParserFactory(logger ik.Logger, formats []*presetFormat) (*PresetLineParserFactory, error) {
+	return &PresetLineParserFactory{
+		plugin:  plugin,
+		logger:  logger,
+		formats: formats,
+	}, nil
+}
+
+var _ = AddPlugin(&PresetLineParserPlugin{})
@@ -0,0 +1,190 @@
+package parsers
+
+import (
+	"bufio"
+	"github.com/moriyoshi/ik"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+type presetTestCase struct {
+	timestamp time.Time
+	data      map[string]interface{}
+}
+
+// doTestPreset parses the lines of testdata/<fixture>.log one by one and
+// compares the results with the cases in the same order.
+func doTestPreset(t *testing.T, name string, attrs map[string]string, fixture string, cases []presetTestCase) {
+	f, err := os.Open(path.Join("testdata", fixture+".log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	i := 0
+	for ; scanner.Scan(); i += 1 {
+		line := scanner.Text()
+		if i >= len(cases) {
+			break
+		}
+		records, err := parseTestLine(t, &PresetLineParserPlugin{}, name, attrs, line)
+		if err != nil || len(records) != 1 {
+			t.Logf("%s: %v", line, err)
+			t.Fail()
+			continue
+		}
+		expected := cases[i]
+		if !records[0].Timestamp.Equal(expected.timestamp) {
+			t.Logf("%s: %s", line, records[0].Timestamp)
+			t.Fail()
+		}
+		data := records[0].Data
+		if len(data) != len(expected.data) {
+			t.Logf("%s: %#v", line, data)
+			t.Fail()
+		}
+		for key, value := range expected.data {
+			if data[key] != value {
+				t.Logf("%s: %s=%#v", line, key, data[key])
+				t.Fail()
+			}
+		}
+	}
+	if i != len(cases) {
+		t.Fail()
+	}
+}
+
+func TestPreset_apache2(t *testing.T) {
+	jst := time.FixedZone("", 9*3600)
+	doTestPreset(t, "apache2", nil, "apache2", []presetTestCase{
+		{
+			time.Date(2013, 2, 28, 12, 0, 0, 0, jst),
+			map[string]interface{}{"host": "192.168.0.1", "user": nil, "method": "GET", "path": "/", "code": int64(200), "size": int64(777), "referer": "-", "agent": "Opera/12.0"},
+		},
+		{
+			time.Date(2013, 2, 28, 12, 0, 0, 0, jst),
+			map[string]interface{}{"host": "192.168.0.1", "user": "frank", "method": "POST", "path": `/a%20b?c=\"d\"`, "code": int64(304), "size": nil, "referer": "http://example.com/", "agent": `Mozilla/5.0 (X11; \"Linux\")`},
+		},
+		{
+			time.Date(2013, 2, 28, 13, 0, 0, 0, time.UTC),
+			map[string]interface{}{"host": "::1", "user": nil, "method": "OPTIONS", "path": "*", "code": int64(200), "size": int64(126)},
+		},
+	})
+}
+
+func TestPreset_apacheError(t *testing.T) {
+	doTestPreset(t, "apache_error", nil, "apache_error", []presetTestCase{
+		{
+			time.Date(2000, 10, 11, 14, 32, 52, 0, time.Local),
+			map[string]interface{}{"level": "error", "client": "127.0.0.1", "message": "client denied by server configuration: /export/home/live/ap/htdocs/test"},
+		},
+		{
+			time.Date(2011, 9, 9, 10, 42, 29, 902022000, time.Local),
+			map[string]interface{}{"level": "core:error", "pid": "35708:tid 4328636416", "client": "72.15.99.187", "message": "File does not exist: /usr/local/apache2/htdocs/favicon.ico"},
+		},
+		{
+			time.Date(2013, 12, 23, 7, 49, 1, 981000000, time.Local),
+			map[string]interface{}{"level": ":notice", "pid": "1:tid 2", "message": "AH00094: Command line: 'httpd -D FOREGROUND'"},
+		},
+	})
+}
+
+func TestPreset_nginx(t *testing.T) {
+	jst := time.FixedZone("", 9*3600)
+	doTestPreset(t, "nginx", nil, "nginx", []presetTestCase{
+		{
+			time.Date(2013, 2, 28, 12, 0, 0, 0, jst),
+			map[string]interface{}{"remote": "127.0.0.1", "host": "192.168.0.1", "user": nil, "method": "GET", "path": "/", "code": int64(200), "size": int64(777), "referer": "-", "agent": "Opera/12.0"},
+		},
+		{
+			time.Date(2013, 2, 28, 12, 0, 0, 0, jst),
+			map[string]interface{}{"remote": "127.0.0.1", "host": nil, "user": "bob", "method": "GET", "path": "/x", "code": int64(502), "size": int64(157), "referer": "http://example.com/", "agent": "curl/7.30.0", "http_x_forwarded_for": "10.0.0.1,10.0.0.2"},
+		},
+		{
+			time.Date(2013, 2, 28, 12, 0, 0, 0, jst),
+			map[string]interface{}{"remote": "127.0.0.1", "host": nil, "user": nil, "method": "-", "code": int64(400), "size": int64(0)},
+		},
+	})
+}
+
+func TestPreset_syslogRFC3164(t *testing.T) {
+	// the year is complemented so that the time is not in the future
+	date := func(month time.Month, day int, hour int, min int, sec int) time.Time {
+		now := time.Now()
+		t := time.Date(now.Year(), month, day, hour, min, sec, 0, time.Local)
+		if t.After(now) {
+			t = time.Date(now.Year()-1, month, day, hour, min, sec, 0, time.Local)
+		}
+		return t
+	}
+	doTestPreset(t, "syslog", nil, "syslog_rfc3164", []presetTestCase{
+		{
+			date(2, 28, 12, 0, 0),
+			map[string]interface{}{"pri": int64(6), "host": "192.168.0.1", "ident": "fluentd", "pid": "11111", "message": "[error] Syslog test"},
+		},
+		{
+			date(2, 1, 2, 3, 4),
+			map[string]interface{}{"host": "host1", "ident": "kernel", "message": "eth0: link up"},
+		},
+		{
+			date(2, 28, 12, 0, 0),
+			map[string]interface{}{"host": "192.168.0.1", "ident": "cron", "message": "(root) CMD (run-parts /etc/cron.hourly)"},
+		},
+	})
+}
+
+func Test_parseRFC3164TimeAt(t *testing.T) {
+	cases := []struct {
+		value    string
+		now      time.Time
+		expected time.Time
+	}{
+		{"Feb 29 12:00:00", time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local), time.Date(2024, 2, 29, 12, 0, 0, 0, time.Local)},
+		{"Feb 29 12:00:00", time.Date(2025, 3, 1, 0, 0, 0, 0, time.Local), time.Date(2024, 2, 29, 12, 0, 0, 0, time.Local)},
+		{"Dec 31 23:59:59", time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local), time.Date(2024, 12, 31, 23, 59, 59, 0, time.Local)},
+		{"Jan  1 00:00:00", time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local), time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)},
+	}
+	for _, c := range cases {
+		result, err := parseRFC3164TimeAt(c.value, c.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Equal(c.expected) {
+			t.Logf("%s: %s", c.value, result)
+			t.Fail()
+		}
+	}
+}
+
+func TestPreset_syslogRFC5424(t *testing.T) {
+	cases := []presetTestCase{
+		{
+			time.Date(2013, 2, 28, 12, 0, 0, 3000000, time.UTC),
+			map[string]interface{}{"pri": int64(16), "host": "192.168.0.1", "ident": "fluentd", "pid": "11111", "msgid": "ID24224", "extradata": `[exampleSDID@20224 iut="3" eventSource="Application" eventID="11211"]`, "message": "Hi, from Fluentd!"},
+		},
+		{
+			time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.FixedZone("", 9*3600)),
+			map[string]interface{}{"pri": int64(34), "host": "mymachine.example.com", "ident": "su", "pid": nil, "msgid": "ID47", "extradata": nil, "message": "'su root' failed for lonvick on /dev/pts/8"},
+		},
+		{
+			time.Date(2003, 8, 24, 5, 14, 15, 3000, time.FixedZone("", -7*3600)),
+			map[string]interface{}{"pri": int64(165), "host": "192.0.2.1", "ident": "myproc", "pid": "8710", "msgid": nil, "extradata": nil},
+		},
+	}
+	doTestPreset(t, "syslog", map[string]string{"message_format": "rfc5424"}, "syslog_rfc5424", cases)
+	doTestPreset(t, "syslog", map[string]string{"message_format": "auto"}, "syslog_rfc5424", cases)
+}
+
+func TestPreset_unparsed(t *testing.T) {
+	_, err := parseTestLine(t, &PresetLineParserPlugin{}, "nginx", nil, "garbage")
+	if _, ok := err.(*ik.ParseError); !ok {
+		t.Fail()
+	}
+	_, err = parseTestLine(t, &PresetLineParserPlugin{}, "apache2", nil, `127.0.0.1 - - [31/Feb/2013:12:00:00 +0900] "GET / HTTP/1.1" 200 777`)
+	if _, ok := err.(*ik.ParseError); !ok {
+		t.Fail()
+	}
+}
@@ -27,15 +27,14 @@ func (parser *RegexpLineParser) Feed(line string) error {
 	g := regex.FindStringSubmatch(line)
 	data := make(map[string]interface{})
 	if g == nil {
-		parser.factory.logger.Error("Unparsed line: " + line)
-		return nil
+		return &ik.ParseError{Line: line, Message: "Unparsed line"}
 	}
 	for i, name := range regex.SubexpNames() {
 		data[name] = g[i]
 	}
 	parser.receiver(ik.FluentRecord{
 		Tag:       "",
-		Timestamp: 0,
+		Timestamp: time.Time{},
 		Data:      data,
 	})
 	return nil
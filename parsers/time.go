@@ -0,0 +1,96 @@
+package parsers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"github.com/pbnjay/strptime"
+	"math"
+	"strconv"
+	"time"
+)
+
+// timeKeyHandler takes the time of the record out of the field designated
+// by time_key, which is parsed with time_format if given.  Without
+// time_format, either a UNIX time or an RFC3339 string is accepted.
+type timeKeyHandler struct {
+	timeKey     string
+	timeParser  func(value string) (time.Time, error)
+	keepTimeKey bool
+}
+
+func parseUnixTime(value string) (time.Time, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9)), nil
+}
+
+func newTimeKeyHandler(config *ik.ConfigElement, defaultTimeKey string) (*timeKeyHandler, error) {
+	timeKey, ok := config.Attrs["time_key"]
+	if !ok {
+		timeKey = defaultTimeKey
+	}
+	var timeParser func(value string) (time.Time, error)
+	timeFormat, ok := config.Attrs["time_format"]
+	if ok {
+		timeParser = func(value string) (time.Time, error) {
+			return strptime.Parse(value, timeFormat)
+		}
+	} else {
+		timeParser = func(value string) (time.Time, error) {
+			retval, err := parseUnixTime(value)
+			if err == nil {
+				return retval, nil
+			}
+			return time.Parse(time.RFC3339Nano, value)
+		}
+	}
+	keepTimeKey := false
+	keepTimeKeyStr, ok := config.Attrs["keep_time_key"]
+	if ok {
+		var err error
+		keepTimeKey, err = strconv.ParseBool(keepTimeKeyStr)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to parse keep_time_key: %s", err.Error()))
+		}
+	}
+	return &timeKeyHandler{
+		timeKey:     timeKey,
+		timeParser:  timeParser,
+		keepTimeKey: keepTimeKey,
+	}, nil
+}
+
+// Extract returns the time of the record, which is the zero time if the
+// record lacks the time key.
+func (handler *timeKeyHandler) Extract(data map[string]interface{}) (time.Time, error) {
+	value, ok := data[handler.timeKey]
+	if !ok || handler.timeKey == "" {
+		return time.Time{}, nil
+	}
+	var retval time.Time
+	switch value_ := value.(type) {
+	case int64:
+		retval = time.Unix(value_, 0)
+	case float64:
+		sec, frac := math.Modf(value_)
+		retval = time.Unix(int64(sec), int64(frac*1e9))
+	default:
+		s, ok := value.(string)
+		if !ok {
+			s = fmt.Sprint(value)
+		}
+		var err error
+		retval, err = handler.timeParser(s)
+		if err != nil {
+			return time.Time{}, errors.New(fmt.Sprintf("invalid time in %s: %s", handler.timeKey, s))
+		}
+	}
+	if !handler.keepTimeKey {
+		delete(data, handler.timeKey)
+	}
+	return retval, nil
+}
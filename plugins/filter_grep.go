@@ -0,0 +1,198 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type grepRule struct {
+	keys []string
+	re   *regexp.Regexp
+}
+
+// grepGroup is the rules in <and> or <or>.  Rules at the top level make
+// up the groups that consist of a single rule.
+type grepGroup struct {
+	rules []*grepRule
+	or    bool
+}
+
+type GrepFilter struct {
+	factory  *GrepFilterFactory
+	logger   ik.Logger
+	regexps  []*grepGroup
+	excludes []*grepGroup
+}
+
+type GrepFilterFactory struct {
+}
+
+func (rule *grepRule) match(data map[string]interface{}) bool {
+	value := lookupRecordValue(data, rule.keys)
+	if value == nil {
+		return false
+	}
+	return rule.re.MatchString(placeholderValueToString(value))
+}
+
+func (group *grepGroup) match(data map[string]interface{}) bool {
+	for _, rule := range group.rules {
+		if rule.match(data) == group.or {
+			return group.or
+		}
+	}
+	return !group.or
+}
+
+func (filter *GrepFilter) keep(data map[string]interface{}) bool {
+	for _, group := range filter.regexps {
+		if !group.match(data) {
+			return false
+		}
+	}
+	for _, group := range filter.excludes {
+		if group.match(data) {
+			return false
+		}
+	}
+	return true
+}
+
+func (filter *GrepFilter) Filter(recordSets []ik.FluentRecordSet) ([]ik.FluentRecordSet, error) {
+	retval := make([]ik.FluentRecordSet, 0, len(recordSets))
+	for _, recordSet := range recordSets {
+		records := make([]ik.TinyFluentRecord, 0, len(recordSet.Records))
+		for _, record := range recordSet.Records {
+			if filter.keep(record.Data) {
+				records = append(records, record)
+			}
+		}
+		if len(records) > 0 {
+			retval = append(retval, ik.FluentRecordSet{Tag: recordSet.Tag, Records: records})
+		}
+	}
+	return retval, nil
+}
+
+func (filter *GrepFilter) Factory() ik.Plugin {
+	return filter.factory
+}
+
+func (filter *GrepFilter) Run() error {
+	time.Sleep(1000000000)
+	return ik.Continue
+}
+
+func (filter *GrepFilter) Shutdown() error {
+	return nil
+}
+
+func newGrepFilter(factory *GrepFilterFactory, logger ik.Logger, regexps []*grepGroup, excludes []*grepGroup) (*GrepFilter, error) {
+	return &GrepFilter{
+		factory:  factory,
+		logger:   logger,
+		regexps:  regexps,
+		excludes: excludes,
+	}, nil
+}
+
+// parseRecordAccessor parses the key that is either a plain name, "$.a.b"
+// or "$['a']['b']".
+func parseRecordAccessor(s string) ([]string, error) {
+	if strings.HasPrefix(s, "$.") {
+		keys := strings.Split(s[2:], ".")
+		for _, key := range keys {
+			if key == "" {
+				return nil, errors.New("invalid key: " + s)
+			}
+		}
+		return keys, nil
+	} else if strings.HasPrefix(s, "$[") {
+		keys, err := parseRecordKeys("record" + s[1:])
+		if err != nil {
+			return nil, errors.New("invalid key: " + s)
+		}
+		return keys, nil
+	}
+	return []string{s}, nil
+}
+
+func parseGrepRule(config *ik.ConfigElement) (*grepRule, error) {
+	key, ok := config.Attrs["key"]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("'key' is not specified in <%s>", config.Name))
+	}
+	keys, err := parseRecordAccessor(key)
+	if err != nil {
+		return nil, err
+	}
+	pattern, ok := config.Attrs["pattern"]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("'pattern' is not specified in <%s>", config.Name))
+	}
+	pattern = ik.StripRegexpDelimiters(pattern)
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to compile pattern %s: %s", pattern, err.Error()))
+	}
+	return &grepRule{keys, re}, nil
+}
+
+func (factory *GrepFilterFactory) Name() string {
+	return "grep"
+}
+
+func (factory *GrepFilterFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Filter, error) {
+	regexps := make([]*grepGroup, 0)
+	excludes := make([]*grepGroup, 0)
+	for _, elem := range config.Elems {
+		switch elem.Name {
+		case "regexp", "exclude":
+			rule, err := parseGrepRule(elem)
+			if err != nil {
+				return nil, err
+			}
+			group := &grepGroup{[]*grepRule{rule}, false}
+			if elem.Name == "regexp" {
+				regexps = append(regexps, group)
+			} else {
+				excludes = append(excludes, group)
+			}
+		case "and", "or":
+			group := &grepGroup{make([]*grepRule, 0), elem.Name == "or"}
+			kind := ""
+			for _, subelem := range elem.Elems {
+				if subelem.Name != "regexp" && subelem.Name != "exclude" {
+					continue
+				}
+				if kind != "" && kind != subelem.Name {
+					return nil, errors.New(fmt.Sprintf("<regexp> and <exclude> cannot be mixed in <%s>", elem.Name))
+				}
+				kind = subelem.Name
+				rule, err := parseGrepRule(subelem)
+				if err != nil {
+					return nil, err
+				}
+				group.rules = append(group.rules, rule)
+			}
+			if kind == "" {
+				return nil, errors.New(fmt.Sprintf("<%s> requires <regexp> or <exclude>", elem.Name))
+			}
+			if kind == "regexp" {
+				regexps = append(regexps, group)
+			} else {
+				excludes = append(excludes, group)
+			}
+		}
+	}
+	return newGrepFilter(factory, engine.Logger(), regexps, excludes)
+}
+
+func (factory *GrepFilterFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+}
+
+var _ = AddPlugin(&GrepFilterFactory{})
@@ -0,0 +1,108 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"testing"
+	"time"
+)
+
+func newTestGrepFilter(t *testing.T, elems []*ik.ConfigElement) ik.Filter {
+	filter, err := (&GrepFilterFactory{}).New(&testEngine{logger: &testLogger{t}}, &ik.ConfigElement{Name: "filter", Attrs: map[string]string{}, Elems: elems})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filter
+}
+
+func grepRuleConfig(name string, key string, pattern string) *ik.ConfigElement {
+	return &ik.ConfigElement{Name: name, Attrs: map[string]string{"key": key, "pattern": pattern}}
+}
+
+func grepTestRecordSets(t *testing.T, filter ik.Filter, data ...map[string]interface{}) []map[string]interface{} {
+	records := make([]ik.TinyFluentRecord, len(data))
+	for i, data_ := range data {
+		records[i] = ik.TinyFluentRecord{Timestamp: time.Unix(1, 0), Data: data_}
+	}
+	recordSets, err := filter.Filter([]ik.FluentRecordSet{{Tag: "test", Records: records}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	retval := make([]map[string]interface{}, 0)
+	for _, recordSet := range recordSets {
+		if recordSet.Tag != "test" {
+			t.Fail()
+		}
+		for _, record := range recordSet.Records {
+			retval = append(retval, record.Data)
+		}
+	}
+	return retval
+}
+
+func TestGrepFilter(t *testing.T) {
+	filter := newTestGrepFilter(t, []*ik.ConfigElement{
+		grepRuleConfig("regexp", "message", "/cool/"),
+		grepRuleConfig("exclude", "$.level.name", "^debug$"),
+	})
+	result := grepTestRecordSets(t, filter,
+		map[string]interface{}{"message": "cool", "level": map[string]interface{}{"name": "info"}},
+		map[string]interface{}{"message": []byte("so cool"), "level": map[string]interface{}{"name": "debug"}},
+		map[string]interface{}{"message": "not", "level": map[string]interface{}{"name": "info"}},
+		map[string]interface{}{"message": "cool"},
+	)
+	if len(result) != 2 || result[0]["level"] == nil || result[1]["level"] != nil {
+		t.Logf("%#v", result)
+		t.Fail()
+	}
+	// record sets that become empty are dropped
+	if len(grepTestRecordSets(t, filter, map[string]interface{}{"message": "not"})) != 0 {
+		t.Fail()
+	}
+}
+
+func TestGrepFilter_group(t *testing.T) {
+	filter := newTestGrepFilter(t, []*ik.ConfigElement{
+		{
+			Name: "or",
+			Elems: []*ik.ConfigElement{
+				grepRuleConfig("regexp", "a", "x"),
+				grepRuleConfig("regexp", "b", "x"),
+			},
+		},
+		{
+			Name: "and",
+			Elems: []*ik.ConfigElement{
+				grepRuleConfig("exclude", "$['c']", "x"),
+				grepRuleConfig("exclude", "d", "x"),
+			},
+		},
+	})
+	result := grepTestRecordSets(t, filter,
+		map[string]interface{}{"a": "x", "id": 1},
+		map[string]interface{}{"b": "x", "c": "x", "id": 2},
+		map[string]interface{}{"b": "x", "c": "x", "d": "x", "id": 3},
+		map[string]interface{}{"c": "x", "id": 4},
+	)
+	if len(result) != 2 || result[0]["id"] != 1 || result[1]["id"] != 2 {
+		t.Logf("%#v", result)
+		t.Fail()
+	}
+}
+
+func TestGrepFilter_mixedGroup(t *testing.T) {
+	_, err := (&GrepFilterFactory{}).New(&testEngine{logger: &testLogger{t}}, &ik.ConfigElement{
+		Name: "filter",
+		Elems: []*ik.ConfigElement{
+			{
+				Name: "and",
+				Elems: []*ik.ConfigElement{
+					grepRuleConfig("regexp", "a", "x"),
+					grepRuleConfig("exclude", "b", "x"),
+				},
+			},
+		},
+	})
+	if err == nil {
+		t.Fail()
+	}
+}
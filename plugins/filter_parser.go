@@ -0,0 +1,218 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type ParserFilter struct {
+	factory                  *ParserFilterFactory
+	logger                   ik.Logger
+	errorStream              *ik.ErrorStream
+	keyName                  string
+	lineParser               ik.LineParser
+	reserveData              bool
+	reserveTime              bool
+	injectKeyPrefix          string
+	hashValueField           string
+	removeKeyNameField       bool
+	emitInvalidRecordToError bool
+	parsed                   []ik.FluentRecord
+	mtx                      sync.Mutex
+}
+
+type ParserFilterFactory struct {
+}
+
+func (filter *ParserFilter) parse(line string) ([]ik.FluentRecord, error) {
+	filter.mtx.Lock()
+	defer filter.mtx.Unlock()
+	filter.parsed = filter.parsed[:0]
+	err := filter.lineParser.Feed(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(filter.parsed) == 0 {
+		// some parsers buffer the line, which can't be taken out here
+		return nil, &ik.ParseError{Line: line, Message: "No record is parsed"}
+	}
+	retval := make([]ik.FluentRecord, len(filter.parsed))
+	copy(retval, filter.parsed)
+	return retval, nil
+}
+
+func (filter *ParserFilter) merge(record ik.TinyFluentRecord, parsed ik.FluentRecord) ik.TinyFluentRecord {
+	data := make(map[string]interface{})
+	if filter.reserveData {
+		for k, v := range record.Data {
+			data[k] = v
+		}
+		if filter.removeKeyNameField {
+			delete(data, filter.keyName)
+		}
+	}
+	parsedData := parsed.Data
+	if filter.injectKeyPrefix != "" {
+		parsedData = make(map[string]interface{}, len(parsed.Data))
+		for k, v := range parsed.Data {
+			parsedData[filter.injectKeyPrefix+k] = v
+		}
+	}
+	if filter.hashValueField != "" {
+		data[filter.hashValueField] = parsedData
+	} else {
+		for k, v := range parsedData {
+			data[k] = v
+		}
+	}
+	timestamp := record.Timestamp
+	// the parsers leave the time zero when the value lacks it
+	if !filter.reserveTime && !parsed.Timestamp.IsZero() {
+		timestamp = parsed.Timestamp
+	}
+	return ik.TinyFluentRecord{Timestamp: timestamp, Data: data}
+}
+
+func (filter *ParserFilter) handleInvalidRecord(tag string, record ik.TinyFluentRecord, err error) {
+	if filter.emitInvalidRecordToError {
+		err = filter.errorStream.EmitError([]ik.FluentRecordSet{{Tag: tag, Records: []ik.TinyFluentRecord{record}}}, err)
+		if err == nil {
+			return
+		}
+	}
+	filter.logger.Warning("parser: %s", err.Error())
+}
+
+func (filter *ParserFilter) Filter(recordSets []ik.FluentRecordSet) ([]ik.FluentRecordSet, error) {
+	retval := make([]ik.FluentRecordSet, 0, len(recordSets))
+	for _, recordSet := range recordSets {
+		records := make([]ik.TinyFluentRecord, 0, len(recordSet.Records))
+		for _, record := range recordSet.Records {
+			value, ok := record.Data[filter.keyName]
+			var err error
+			if ok {
+				line, ok := stringify(value)
+				if ok {
+					var parsed []ik.FluentRecord
+					parsed, err = filter.parse(line)
+					if err == nil {
+						for _, parsedRecord := range parsed {
+							records = append(records, filter.merge(record, parsedRecord))
+						}
+						continue
+					}
+				} else {
+					err = errors.New(fmt.Sprintf("%s is not a string", filter.keyName))
+				}
+			} else {
+				err = errors.New(fmt.Sprintf("%s does not exist", filter.keyName))
+			}
+			filter.handleInvalidRecord(recordSet.Tag, record, err)
+			if filter.reserveData {
+				records = append(records, record)
+			}
+		}
+		if len(records) > 0 {
+			retval = append(retval, ik.FluentRecordSet{Tag: recordSet.Tag, Records: records})
+		}
+	}
+	return retval, nil
+}
+
+func (filter *ParserFilter) Factory() ik.Plugin {
+	return filter.factory
+}
+
+func (filter *ParserFilter) Run() error {
+	time.Sleep(1000000000)
+	return ik.Continue
+}
+
+func (filter *ParserFilter) Shutdown() error {
+	return nil
+}
+
+func (factory *ParserFilterFactory) Name() string {
+	return "parser"
+}
+
+func parseBoolAttr(config *ik.ConfigElement, name string, defaultValue bool) (bool, error) {
+	s, ok := config.Attrs[name]
+	if !ok {
+		return defaultValue, nil
+	}
+	retval, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, errors.New(fmt.Sprintf("Failed to parse %s: %s", name, err.Error()))
+	}
+	return retval, nil
+}
+
+func (factory *ParserFilterFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Filter, error) {
+	keyName, ok := config.Attrs["key_name"]
+	if !ok {
+		return nil, errors.New("'key_name' is not specified")
+	}
+	// parameters of the parser are given either in <parse> or in place
+	parserConfig := findConfigElement(config, "parse")
+	format := ""
+	if parserConfig != nil {
+		format = parserConfig.Type()
+	} else {
+		parserConfig = config
+		format = config.Attrs["format"]
+	}
+	if format == "" {
+		return nil, errors.New("'format' is not specified")
+	}
+	lineParserFactoryFactory := engine.LineParserPluginRegistry().LookupLineParserFactoryFactory(format)
+	if lineParserFactoryFactory == nil {
+		return nil, errors.New(fmt.Sprintf("Format `%s' is not supported", format))
+	}
+	lineParserFactory, err := lineParserFactoryFactory(engine, parserConfig)
+	if err != nil {
+		return nil, err
+	}
+	filter := &ParserFilter{
+		factory:         factory,
+		logger:          engine.Logger(),
+		errorStream:     engine.ErrorStream(),
+		keyName:         keyName,
+		injectKeyPrefix: config.Attrs["inject_key_prefix"],
+		hashValueField:  config.Attrs["hash_value_field"],
+		parsed:          make([]ik.FluentRecord, 0, 1),
+	}
+	filter.reserveData, err = parseBoolAttr(config, "reserve_data", false)
+	if err != nil {
+		return nil, err
+	}
+	filter.reserveTime, err = parseBoolAttr(config, "reserve_time", false)
+	if err != nil {
+		return nil, err
+	}
+	filter.removeKeyNameField, err = parseBoolAttr(config, "remove_key_name_field", false)
+	if err != nil {
+		return nil, err
+	}
+	filter.emitInvalidRecordToError, err = parseBoolAttr(config, "emit_invalid_record_to_error", true)
+	if err != nil {
+		return nil, err
+	}
+	filter.lineParser, err = lineParserFactory.New(func(record ik.FluentRecord) error {
+		filter.parsed = append(filter.parsed, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+func (factory *ParserFilterFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+}
+
+var _ = AddPlugin(&ParserFilterFactory{})
@@ -0,0 +1,119 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"testing"
+	"time"
+)
+
+func newTestParserFilter(t *testing.T, engine *testEngine, attrs map[string]string) ik.Filter {
+	attrs["key_name"] = "log"
+	attrs["format"] = "regexp"
+	attrs["regexp"] = "^(?P<method>[A-Z]+) (?P<path>[^ ]+)$"
+	filter, err := (&ParserFilterFactory{}).New(engine, &ik.ConfigElement{Name: "filter", Attrs: attrs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filter
+}
+
+func parseTestRecords(t *testing.T, filter ik.Filter, data ...map[string]interface{}) []ik.TinyFluentRecord {
+	records := make([]ik.TinyFluentRecord, len(data))
+	for i, data_ := range data {
+		records[i] = ik.TinyFluentRecord{Timestamp: time.Unix(1, 0), Data: data_}
+	}
+	recordSets, err := filter.Filter([]ik.FluentRecordSet{{Tag: "test", Records: records}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recordSets) == 0 {
+		return nil
+	}
+	return recordSets[0].Records
+}
+
+func TestParserFilter(t *testing.T) {
+	engine := &testEngine{logger: &testLogger{t}, errorStream: ik.NewErrorStream(&testLogger{t})}
+	filter := newTestParserFilter(t, engine, map[string]string{})
+	records := parseTestRecords(t, filter,
+		map[string]interface{}{"log": []byte("GET /"), "stream": "stdout"},
+		map[string]interface{}{"log": "broken"},
+	)
+	if len(records) != 1 {
+		t.FailNow()
+	}
+	data := records[0].Data
+	if data["method"] != "GET" || data["path"] != "/" || data["stream"] != nil {
+		t.Logf("%#v", data)
+		t.Fail()
+	}
+	if !records[0].Timestamp.Equal(time.Unix(1, 0)) {
+		t.Fail()
+	}
+}
+
+func TestParserFilter_noTime(t *testing.T) {
+	engine := &testEngine{logger: &testLogger{t}, errorStream: ik.NewErrorStream(&testLogger{t})}
+	filter, err := (&ParserFilterFactory{}).New(engine, &ik.ConfigElement{Name: "filter", Attrs: map[string]string{
+		"key_name": "log",
+		"format":   "json",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := parseTestRecords(t, filter, map[string]interface{}{"log": `{"a": 1}`})
+	if len(records) != 1 {
+		t.FailNow()
+	}
+	if !records[0].Timestamp.Equal(time.Unix(1, 0)) || records[0].Data["a"] != int64(1) {
+		t.Log(records[0].Timestamp)
+		t.Fail()
+	}
+}
+
+func TestParserFilter_reserveData(t *testing.T) {
+	engine := &testEngine{logger: &testLogger{t}, errorStream: ik.NewErrorStream(&testLogger{t})}
+	errorPort := &testPort{}
+	engine.errorStream.SetPort(errorPort)
+	filter := newTestParserFilter(t, engine, map[string]string{
+		"reserve_data":          "true",
+		"inject_key_prefix":     "p_",
+		"remove_key_name_field": "true",
+	})
+	records := parseTestRecords(t, filter,
+		map[string]interface{}{"log": "GET /", "stream": "stdout"},
+		map[string]interface{}{"log": "broken"},
+	)
+	if len(records) != 2 {
+		t.FailNow()
+	}
+	data := records[0].Data
+	if data["p_method"] != "GET" || data["stream"] != "stdout" || data["log"] != nil {
+		t.Logf("%#v", data)
+		t.Fail()
+	}
+	// an invalid record goes through as is, and to @ERROR as well
+	if records[1].Data["log"] != "broken" {
+		t.Fail()
+	}
+	if len(errorPort.recordSets) != 1 || errorPort.recordSets[0].Records[0].Data["error"] == nil {
+		t.Fail()
+	}
+}
+
+func TestParserFilter_hashValueField(t *testing.T) {
+	engine := &testEngine{logger: &testLogger{t}, errorStream: ik.NewErrorStream(&testLogger{t})}
+	filter := newTestParserFilter(t, engine, map[string]string{
+		"reserve_data":     "true",
+		"hash_value_field": "parsed",
+	})
+	records := parseTestRecords(t, filter, map[string]interface{}{"log": "GET /"})
+	if len(records) != 1 {
+		t.FailNow()
+	}
+	parsed, ok := records[0].Data["parsed"].(map[string]interface{})
+	if !ok || parsed["method"] != "GET" || records[0].Data["log"] != "GET /" {
+		t.Logf("%#v", records[0].Data)
+		t.Fail()
+	}
+}
@@ -0,0 +1,316 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// placeholderContext holds what placeholders are expanded against.
+type placeholderContext struct {
+	tag       string
+	tagParts  []string
+	timestamp time.Time
+	hostname  string
+	data      map[string]interface{}
+}
+
+type placeholder func(context *placeholderContext) interface{}
+
+// placeholderTemplate is a compiled string like "${tag}-${record["a"]}".
+type placeholderTemplate struct {
+	literals     []string
+	placeholders []placeholder
+}
+
+type recordTransformerField struct {
+	key      string
+	template *placeholderTemplate
+}
+
+type RecordTransformerFilter struct {
+	factory     *RecordTransformerFilterFactory
+	logger      ik.Logger
+	hostname    string
+	fields      []recordTransformerField
+	removeKeys  []string
+	keepKeys    []string
+	renewRecord bool
+}
+
+type RecordTransformerFilterFactory struct {
+}
+
+func indexedTagPart(tagParts []string, index int, fn func(tagParts []string, index int) string) string {
+	if index < 0 {
+		index += len(tagParts)
+	}
+	if index < 0 || index >= len(tagParts) {
+		return ""
+	}
+	return fn(tagParts, index)
+}
+
+// parseRecordKeys parses `record["a"]["b"]` into the list of the keys.
+func parseRecordKeys(expr string) ([]string, error) {
+	keys := make([]string, 0, 1)
+	rest := expr[len("record"):]
+	for len(rest) > 0 {
+		if len(rest) < 4 || rest[0] != '[' || (rest[1] != '"' && rest[1] != '\'') {
+			return nil, errors.New("invalid record reference: " + expr)
+		}
+		end := strings.IndexByte(rest[2:], rest[1])
+		if end < 0 || len(rest) < end+4 || rest[end+3] != ']' {
+			return nil, errors.New("invalid record reference: " + expr)
+		}
+		keys = append(keys, rest[2:end+2])
+		rest = rest[end+4:]
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("invalid record reference: " + expr)
+	}
+	return keys, nil
+}
+
+func lookupRecordValue(data map[string]interface{}, keys []string) interface{} {
+	var value interface{} = data
+	for _, key := range keys {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value, ok = m[key]
+		if !ok {
+			return nil
+		}
+	}
+	return value
+}
+
+func compilePlaceholder(expr string) (placeholder, error) {
+	expr = strings.TrimSpace(expr)
+	switch expr {
+	case "tag":
+		return func(context *placeholderContext) interface{} { return context.tag }, nil
+	case "time":
+		return func(context *placeholderContext) interface{} { return context.timestamp.Format(time.RFC3339) }, nil
+	case "hostname":
+		return func(context *placeholderContext) interface{} { return context.hostname }, nil
+	}
+	if strings.HasPrefix(expr, "record[") {
+		keys, err := parseRecordKeys(expr)
+		if err != nil {
+			return nil, err
+		}
+		return func(context *placeholderContext) interface{} { return lookupRecordValue(context.data, keys) }, nil
+	}
+	for _, name := range []string{"tag_parts", "tag_prefix", "tag_suffix"} {
+		if !strings.HasPrefix(expr, name+"[") || !strings.HasSuffix(expr, "]") {
+			continue
+		}
+		index, err := strconv.Atoi(expr[len(name)+1 : len(expr)-1])
+		if err != nil {
+			return nil, errors.New("invalid index in placeholder: " + expr)
+		}
+		var fn func(tagParts []string, index int) string
+		switch name {
+		case "tag_parts":
+			fn = func(tagParts []string, index int) string { return tagParts[index] }
+		case "tag_prefix":
+			fn = func(tagParts []string, index int) string { return strings.Join(tagParts[0:index+1], ".") }
+		case "tag_suffix":
+			fn = func(tagParts []string, index int) string { return strings.Join(tagParts[index:], ".") }
+		}
+		return func(context *placeholderContext) interface{} {
+			return indexedTagPart(context.tagParts, index, fn)
+		}, nil
+	}
+	if expr == "" || strings.ContainsAny(expr, "[]{}$\"' ") {
+		return nil, errors.New("invalid placeholder: ${" + expr + "}")
+	}
+	// a bare name refers to the field of the record
+	return func(context *placeholderContext) interface{} { return context.data[expr] }, nil
+}
+
+func compilePlaceholderTemplate(s string) (*placeholderTemplate, error) {
+	retval := &placeholderTemplate{
+		literals:     make([]string, 0, 1),
+		placeholders: make([]placeholder, 0),
+	}
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			return nil, errors.New("unterminated placeholder: " + s)
+		}
+		// record["}"] is not supported in exchange for the simplicity
+		placeholder, err := compilePlaceholder(s[start+2 : start+end])
+		if err != nil {
+			return nil, err
+		}
+		retval.literals = append(retval.literals, s[0:start])
+		retval.placeholders = append(retval.placeholders, placeholder)
+		s = s[start+end+1:]
+	}
+	retval.literals = append(retval.literals, s)
+	return retval, nil
+}
+
+func placeholderValueToString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	s, ok := stringify(value)
+	if ok {
+		return s
+	}
+	return fmt.Sprint(value)
+}
+
+// Expand renders the template.  A template that solely consists of a
+// placeholder yields the value as is so that the type is preserved.
+func (template *placeholderTemplate) Expand(context *placeholderContext) interface{} {
+	if len(template.placeholders) == 1 && template.literals[0] == "" && template.literals[1] == "" {
+		return template.placeholders[0](context)
+	}
+	retval := template.literals[0]
+	for i, placeholder := range template.placeholders {
+		retval += placeholderValueToString(placeholder(context)) + template.literals[i+1]
+	}
+	return retval
+}
+
+func (template *placeholderTemplate) ExpandString(context *placeholderContext) string {
+	return placeholderValueToString(template.Expand(context))
+}
+
+func (filter *RecordTransformerFilter) transform(tag string, tagParts []string, record ik.TinyFluentRecord) ik.TinyFluentRecord {
+	var data map[string]interface{}
+	if filter.renewRecord {
+		data = make(map[string]interface{})
+		for _, key := range filter.keepKeys {
+			value, ok := record.Data[key]
+			if ok {
+				data[key] = value
+			}
+		}
+	} else {
+		data = make(map[string]interface{}, len(record.Data)+len(filter.fields))
+		for key, value := range record.Data {
+			data[key] = value
+		}
+	}
+	context := &placeholderContext{
+		tag:       tag,
+		tagParts:  tagParts,
+		timestamp: record.Timestamp,
+		hostname:  filter.hostname,
+		data:      record.Data,
+	}
+	for _, field := range filter.fields {
+		data[field.key] = field.template.Expand(context)
+	}
+	for _, key := range filter.removeKeys {
+		delete(data, key)
+	}
+	return ik.TinyFluentRecord{Timestamp: record.Timestamp, Data: data}
+}
+
+func (filter *RecordTransformerFilter) Filter(recordSets []ik.FluentRecordSet) ([]ik.FluentRecordSet, error) {
+	retval := make([]ik.FluentRecordSet, len(recordSets))
+	for i, recordSet := range recordSets {
+		tagParts := strings.Split(recordSet.Tag, ".")
+		records := make([]ik.TinyFluentRecord, len(recordSet.Records))
+		for j, record := range recordSet.Records {
+			records[j] = filter.transform(recordSet.Tag, tagParts, record)
+		}
+		retval[i] = ik.FluentRecordSet{Tag: recordSet.Tag, Records: records}
+	}
+	return retval, nil
+}
+
+func (filter *RecordTransformerFilter) Factory() ik.Plugin {
+	return filter.factory
+}
+
+func (filter *RecordTransformerFilter) Run() error {
+	time.Sleep(1000000000)
+	return ik.Continue
+}
+
+func (filter *RecordTransformerFilter) Shutdown() error {
+	return nil
+}
+
+func newRecordTransformerFilter(factory *RecordTransformerFilterFactory, logger ik.Logger, hostname string, fields []recordTransformerField, removeKeys []string, keepKeys []string, renewRecord bool) (*RecordTransformerFilter, error) {
+	return &RecordTransformerFilter{
+		factory:     factory,
+		logger:      logger,
+		hostname:    hostname,
+		fields:      fields,
+		removeKeys:  removeKeys,
+		keepKeys:    keepKeys,
+		renewRecord: renewRecord,
+	}, nil
+}
+
+func splitKeys(s string) []string {
+	retval := make([]string, 0)
+	for _, key := range strings.Split(s, ",") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			retval = append(retval, key)
+		}
+	}
+	return retval
+}
+
+func (factory *RecordTransformerFilterFactory) Name() string {
+	return "record_transformer"
+}
+
+func (factory *RecordTransformerFilterFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Filter, error) {
+	fields := make([]recordTransformerField, 0)
+	recordConfig := findConfigElement(config, "record")
+	if recordConfig != nil {
+		keys := make([]string, 0, len(recordConfig.Attrs))
+		for key := range recordConfig.Attrs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			template, err := compilePlaceholderTemplate(recordConfig.Attrs[key])
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Failed to parse the value of %s: %s", key, err.Error()))
+			}
+			fields = append(fields, recordTransformerField{key, template})
+		}
+	}
+	removeKeys := splitKeys(config.Attrs["remove_keys"])
+	keepKeys := splitKeys(config.Attrs["keep_keys"])
+	renewRecord := false
+	renewRecordStr, ok := config.Attrs["renew_record"]
+	if ok {
+		var err error
+		renewRecord, err = strconv.ParseBool(renewRecordStr)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to parse renew_record: %s", err.Error()))
+		}
+	}
+	if len(keepKeys) > 0 && !renewRecord {
+		return nil, errors.New("keep_keys requires renew_record to be true")
+	}
+	return newRecordTransformerFilter(factory, engine.Logger(), defaultHostname(), fields, removeKeys, keepKeys, renewRecord)
+}
+
+func (factory *RecordTransformerFilterFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+}
+
+var _ = AddPlugin(&RecordTransformerFilterFactory{})
@@ -0,0 +1,105 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"testing"
+	"time"
+)
+
+func newTestRecordTransformerFilter(t *testing.T, config *ik.ConfigElement) ik.Filter {
+	filter, err := (&RecordTransformerFilterFactory{}).New(&testEngine{logger: &testLogger{t}}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter.(*RecordTransformerFilter).hostname = "host"
+	return filter
+}
+
+func applyTestFilter(t *testing.T, filter ik.Filter, tag string, data map[string]interface{}) map[string]interface{} {
+	recordSets, err := filter.Filter([]ik.FluentRecordSet{
+		{Tag: tag, Records: []ik.TinyFluentRecord{{Timestamp: time.Unix(0, 0).UTC(), Data: data}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recordSets) != 1 || len(recordSets[0].Records) != 1 {
+		t.Fatalf("%#v", recordSets)
+	}
+	return recordSets[0].Records[0].Data
+}
+
+func TestRecordTransformerFilter(t *testing.T) {
+	filter := newTestRecordTransformerFilter(t, &ik.ConfigElement{
+		Attrs: map[string]string{"remove_keys": "old, secret"},
+		Elems: []*ik.ConfigElement{
+			{
+				Name: "record",
+				Attrs: map[string]string{
+					"host":    "${hostname}",
+					"tag":     "${tag}",
+					"service": "${tag_parts[1]}-${tag_parts[-1]}",
+					"prefix":  "${tag_prefix[1]}",
+					"suffix":  "${tag_suffix[1]}",
+					"time":    "${time}",
+					"new":     "${old}",
+					"nested":  `${record["a"]["b"]}!`,
+					"a":       "overwritten",
+				},
+			},
+		},
+	})
+	data := applyTestFilter(t, filter, "app.web.access", map[string]interface{}{
+		"old":    int64(1),
+		"secret": "x",
+		"a":      map[string]interface{}{"b": []byte("c")},
+	})
+	expected := map[string]interface{}{
+		"host":    "host",
+		"tag":     "app.web.access",
+		"service": "web-access",
+		"prefix":  "app.web",
+		"suffix":  "web.access",
+		"time":    "1970-01-01T00:00:00Z",
+		"new":     int64(1),
+		"nested":  "c!",
+		"a":       "overwritten",
+	}
+	if len(data) != len(expected) {
+		t.Logf("%#v", data)
+		t.Fail()
+	}
+	for key, value := range expected {
+		if data[key] != value {
+			t.Logf("%s: %#v", key, data[key])
+			t.Fail()
+		}
+	}
+}
+
+func TestRecordTransformerFilter_renewRecord(t *testing.T) {
+	filter := newTestRecordTransformerFilter(t, &ik.ConfigElement{
+		Attrs: map[string]string{"renew_record": "true", "keep_keys": "a"},
+		Elems: []*ik.ConfigElement{
+			{Name: "record", Attrs: map[string]string{"c": "${b}"}},
+		},
+	})
+	original := map[string]interface{}{"a": "1", "b": "2", "d": "3"}
+	data := applyTestFilter(t, filter, "test", original)
+	if len(data) != 2 || data["a"] != "1" || data["c"] != "2" {
+		t.Logf("%#v", data)
+		t.Fail()
+	}
+	if len(original) != 3 {
+		t.Fail()
+	}
+}
+
+func TestCompilePlaceholderTemplate_invalid(t *testing.T) {
+	for _, s := range []string{"${tag", "${tag_parts[x]}", `${record[a]}`, "${}"} {
+		_, err := compilePlaceholderTemplate(s)
+		if err == nil {
+			t.Log(s)
+			t.Fail()
+		}
+	}
+}
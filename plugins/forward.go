@@ -0,0 +1,307 @@
+package plugins
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"github.com/ugorji/go/codec"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// forwardSecurity holds the parameters for the shared-key (and optionally
+// user) authentication handshake of the forward protocol v1.
+type forwardSecurity struct {
+	selfHostname string
+	sharedKey    string
+	userAuth     bool
+	users        map[string]string
+}
+
+// forwardCredentials is what a client presents during the handshake.
+type forwardCredentials struct {
+	selfHostname string
+	sharedKey    string
+	username     string
+	password     string
+}
+
+// eventTime is the EventTime representation of the forward protocol v1,
+// which is transferred as the msgpack extension type 0.
+type eventTime struct {
+	sec  uint32
+	nsec uint32
+}
+
+type eventTimeExt struct{}
+
+const eventTimeExtType = 0
+
+func newEventTime(t time.Time) eventTime {
+	return eventTime{
+		sec:  uint32(t.Unix()),
+		nsec: uint32(t.Nanosecond()),
+	}
+}
+
+func (t eventTime) Time() time.Time {
+	return time.Unix(int64(t.sec), int64(t.nsec))
+}
+
+func (eventTimeExt) WriteExt(v interface{}) []byte {
+	var t eventTime
+	switch v_ := v.(type) {
+	case eventTime:
+		t = v_
+	case *eventTime:
+		t = *v_
+	default:
+		panic("unexpected type")
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint32(b[0:4], t.sec)
+	binary.BigEndian.PutUint32(b[4:8], t.nsec)
+	return b
+}
+
+func (eventTimeExt) ReadExt(dst interface{}, src []byte) {
+	t := dst.(*eventTime)
+	if len(src) != 8 {
+		panic("invalid EventTime")
+	}
+	t.sec = binary.BigEndian.Uint32(src[0:4])
+	t.nsec = binary.BigEndian.Uint32(src[4:8])
+}
+
+func newForwardCodec() *codec.MsgpackHandle {
+	_codec := &codec.MsgpackHandle{}
+	_codec.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	_codec.RawToString = false
+	_codec.StructToArray = true
+	_codec.WriteExt = true
+	_codec.SetBytesExt(reflect.TypeOf(eventTime{}), eventTimeExtType, eventTimeExt{})
+	return _codec
+}
+
+func sha512Hex(parts ...string) string {
+	h := sha512.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// digestMatches compares the digests in constant time so as not to leak
+// how much of them matched.
+func digestMatches(digest string, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(digest), []byte(expected)) == 1
+}
+
+func generateSalt() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func defaultHostname() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "localhost"
+	}
+	return hostname
+}
+
+func findConfigElement(config *ik.ConfigElement, name string) *ik.ConfigElement {
+	for _, elem := range config.Elems {
+		if elem.Name == name {
+			return elem
+		}
+	}
+	return nil
+}
+
+// parseForwardSecurity reads the <security> element. nil is returned when
+// there is no such element.
+func parseForwardSecurity(config *ik.ConfigElement) (*forwardSecurity, error) {
+	securityConfig := findConfigElement(config, "security")
+	if securityConfig == nil {
+		return nil, nil
+	}
+	security := &forwardSecurity{
+		selfHostname: defaultHostname(),
+		users:        make(map[string]string),
+	}
+	selfHostname, ok := securityConfig.Attrs["self_hostname"]
+	if ok {
+		security.selfHostname = selfHostname
+	}
+	security.sharedKey, ok = securityConfig.Attrs["shared_key"]
+	if !ok {
+		return nil, errors.New("required attribute `shared_key' is not specified in <security>")
+	}
+	userAuthStr, ok := securityConfig.Attrs["user_auth"]
+	if ok {
+		var err error
+		security.userAuth, err = strconv.ParseBool(userAuthStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, userConfig := range securityConfig.Elems {
+		if userConfig.Name != "user" {
+			continue
+		}
+		username, ok := userConfig.Attrs["username"]
+		if !ok {
+			return nil, errors.New("required attribute `username' is not specified in <user>")
+		}
+		password, ok := userConfig.Attrs["password"]
+		if !ok {
+			return nil, errors.New("required attribute `password' is not specified in <user>")
+		}
+		security.users[username] = password
+	}
+	if security.userAuth && len(security.users) == 0 {
+		return nil, errors.New("user_auth is enabled but no <user> is given")
+	}
+	return security, nil
+}
+
+func decodeHandshakeMessage(dec *codec.Decoder, expectedType string, minimumLength int) ([]interface{}, error) {
+	message := make([]interface{}, 0, 6)
+	err := dec.Decode(&message)
+	if err != nil {
+		return nil, err
+	}
+	if len(message) < minimumLength {
+		return nil, errors.New(fmt.Sprintf("malformed %s message", expectedType))
+	}
+	type_, _ := stringify(message[0])
+	if type_ != expectedType {
+		return nil, errors.New(fmt.Sprintf("expected %s, got %s", expectedType, type_))
+	}
+	return message, nil
+}
+
+// serverHandshake performs the server side of the handshake:
+// HELO -> PING -> PONG.
+func (security *forwardSecurity) serverHandshake(enc *codec.Encoder, dec *codec.Decoder) error {
+	nonce, err := generateSalt()
+	if err != nil {
+		return err
+	}
+	authSalt := ""
+	if security.userAuth {
+		authSalt, err = generateSalt()
+		if err != nil {
+			return err
+		}
+	}
+	err = enc.Encode([]interface{}{
+		"HELO",
+		map[string]interface{}{
+			"nonce":     []byte(nonce),
+			"auth":      []byte(authSalt),
+			"keepalive": true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	ping, err := decodeHandshakeMessage(dec, "PING", 6)
+	if err != nil {
+		return err
+	}
+	clientHostname, _ := stringify(ping[1])
+	sharedKeySalt, _ := stringify(ping[2])
+	sharedKeyDigest, _ := stringify(ping[3])
+	username, _ := stringify(ping[4])
+	passwordDigest, _ := stringify(ping[5])
+
+	reason := ""
+	if clientHostname == security.selfHostname {
+		reason = "same hostname between input and output: invalid configuration"
+	} else if !digestMatches(sharedKeyDigest, sha512Hex(sharedKeySalt, clientHostname, nonce, security.sharedKey)) {
+		reason = "shared_key mismatch"
+	} else if security.userAuth {
+		password, ok := security.users[username]
+		if !ok || !digestMatches(passwordDigest, sha512Hex(authSalt, username, password)) {
+			reason = "username/password mismatch"
+		}
+	}
+	err = enc.Encode([]interface{}{
+		"PONG",
+		reason == "",
+		reason,
+		security.selfHostname,
+		sha512Hex(sharedKeySalt, security.selfHostname, nonce, security.sharedKey),
+	})
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		return errors.New(fmt.Sprintf("authentication failed for %s: %s", clientHostname, reason))
+	}
+	return nil
+}
+
+// clientHandshake performs the client side of the handshake.
+func (credentials *forwardCredentials) clientHandshake(enc *codec.Encoder, dec *codec.Decoder) error {
+	helo, err := decodeHandshakeMessage(dec, "HELO", 2)
+	if err != nil {
+		return err
+	}
+	options, ok := helo[1].(map[string]interface{})
+	if !ok {
+		return errors.New("malformed HELO message")
+	}
+	nonce, _ := stringify(options["nonce"])
+	authSalt, _ := stringify(options["auth"])
+	sharedKeySalt, err := generateSalt()
+	if err != nil {
+		return err
+	}
+	passwordDigest := ""
+	if authSalt != "" {
+		passwordDigest = sha512Hex(authSalt, credentials.username, credentials.password)
+	}
+	err = enc.Encode([]interface{}{
+		"PING",
+		credentials.selfHostname,
+		[]byte(sharedKeySalt),
+		sha512Hex(sharedKeySalt, credentials.selfHostname, nonce, credentials.sharedKey),
+		credentials.username,
+		passwordDigest,
+	})
+	if err != nil {
+		return err
+	}
+	pong, err := decodeHandshakeMessage(dec, "PONG", 5)
+	if err != nil {
+		return err
+	}
+	authResult, _ := pong[1].(bool)
+	if !authResult {
+		reason, _ := stringify(pong[2])
+		return errors.New("authentication failed: " + reason)
+	}
+	serverHostname, _ := stringify(pong[3])
+	sharedKeyDigest, _ := stringify(pong[4])
+	if serverHostname == credentials.selfHostname {
+		return errors.New("same hostname between input and output: invalid configuration")
+	}
+	if !digestMatches(sharedKeyDigest, sha512Hex(sharedKeySalt, serverHostname, nonce, credentials.sharedKey)) {
+		return errors.New("shared_key mismatch")
+	}
+	return nil
+}
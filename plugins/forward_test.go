@@ -0,0 +1,84 @@
+package plugins
+
+import (
+	"github.com/ugorji/go/codec"
+	"net"
+	"testing"
+)
+
+func doTestHandshake(security *forwardSecurity, credentials *forwardCredentials) (error, error) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	_codec := newForwardCodec()
+	serverResult := make(chan error, 1)
+	go func() {
+		err := security.serverHandshake(codec.NewEncoder(server, _codec), codec.NewDecoder(server, _codec))
+		if err != nil {
+			// unblock the client in case it is still waiting
+			server.Close()
+		}
+		serverResult <- err
+	}()
+	clientErr := credentials.clientHandshake(codec.NewEncoder(client, _codec), codec.NewDecoder(client, _codec))
+	return <-serverResult, clientErr
+}
+
+func TestForwardHandshake(t *testing.T) {
+	security := &forwardSecurity{
+		selfHostname: "server",
+		sharedKey:    "secret",
+		users:        map[string]string{},
+	}
+	serverErr, clientErr := doTestHandshake(security, &forwardCredentials{
+		selfHostname: "client",
+		sharedKey:    "secret",
+	})
+	if serverErr != nil || clientErr != nil {
+		t.Log(serverErr, clientErr)
+		t.Fail()
+	}
+}
+
+func TestForwardHandshake_sharedKeyMismatch(t *testing.T) {
+	security := &forwardSecurity{
+		selfHostname: "server",
+		sharedKey:    "secret",
+		users:        map[string]string{},
+	}
+	serverErr, clientErr := doTestHandshake(security, &forwardCredentials{
+		selfHostname: "client",
+		sharedKey:    "wrong",
+	})
+	if serverErr == nil || clientErr == nil {
+		t.Fail()
+	}
+}
+
+func TestForwardHandshake_userAuth(t *testing.T) {
+	security := &forwardSecurity{
+		selfHostname: "server",
+		sharedKey:    "secret",
+		userAuth:     true,
+		users:        map[string]string{"alice": "pass"},
+	}
+	serverErr, clientErr := doTestHandshake(security, &forwardCredentials{
+		selfHostname: "client",
+		sharedKey:    "secret",
+		username:     "alice",
+		password:     "pass",
+	})
+	if serverErr != nil || clientErr != nil {
+		t.Log(serverErr, clientErr)
+		t.Fail()
+	}
+	serverErr, clientErr = doTestHandshake(security, &forwardCredentials{
+		selfHostname: "client",
+		sharedKey:    "secret",
+		username:     "alice",
+		password:     "wrong",
+	})
+	if serverErr == nil || clientErr == nil {
+		t.Fail()
+	}
+}
@@ -0,0 +1,111 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"github.com/moriyoshi/ik/parsers"
+	"testing"
+)
+
+type testLogger struct{ t *testing.T }
+
+func (logger *testLogger) Critical(format string, args ...interface{}) {
+	logger.t.Logf(format, args...)
+}
+
+func (logger *testLogger) Error(format string, args ...interface{}) {
+	logger.t.Logf(format, args...)
+}
+
+func (logger *testLogger) Warning(format string, args ...interface{}) {
+	logger.t.Logf(format, args...)
+}
+
+func (logger *testLogger) Notice(format string, args ...interface{}) {
+	logger.t.Logf(format, args...)
+}
+
+func (logger *testLogger) Info(format string, args ...interface{}) {
+	logger.t.Logf(format, args...)
+}
+
+func (logger *testLogger) Debug(format string, args ...interface{}) {
+	logger.t.Logf(format, args...)
+}
+
+type testPort struct {
+	recordSets []ik.FluentRecordSet
+	err        error
+}
+
+func (port *testPort) Emit(recordSets []ik.FluentRecordSet) error {
+	if port.err != nil {
+		return port.err
+	}
+	port.recordSets = append(port.recordSets, recordSets...)
+	return nil
+}
+
+// testEngine provides the subset of ik.Engine that the factories use.
+type testEngine struct {
+	ik.Engine
+	logger                   ik.Logger
+	port                     ik.Port
+	errorStream              *ik.ErrorStream
+	lineParserPluginRegistry ik.LineParserPluginRegistry
+}
+
+func (engine *testEngine) Logger() ik.Logger {
+	return engine.logger
+}
+
+func (engine *testEngine) DefaultPort() ik.Port {
+	return engine.port
+}
+
+func (engine *testEngine) ErrorStream() *ik.ErrorStream {
+	return engine.errorStream
+}
+
+// LineParserPluginRegistry returns the registry of all the parsers unless
+// one is given.
+func (engine *testEngine) LineParserPluginRegistry() ik.LineParserPluginRegistry {
+	if engine.lineParserPluginRegistry == nil {
+		registry := &testLineParserPluginRegistry{make(map[string]ik.LineParserFactoryFactory)}
+		for _, plugin := range parsers.GetPlugins() {
+			registry.RegisterLineParserPlugin(plugin)
+		}
+		engine.lineParserPluginRegistry = registry
+	}
+	return engine.lineParserPluginRegistry
+}
+
+type testLineParserPluginRegistry struct {
+	factories map[string]ik.LineParserFactoryFactory
+}
+
+func (registry *testLineParserPluginRegistry) RegisterLineParserPlugin(plugin ik.LineParserPlugin) error {
+	return plugin.OnRegistering(func(name string, factory ik.LineParserFactoryFactory) error {
+		registry.factories[name] = factory
+		return nil
+	})
+}
+
+func (registry *testLineParserPluginRegistry) LookupLineParserFactoryFactory(name string) ik.LineParserFactoryFactory {
+	return registry.factories[name]
+}
+
+type testOutput struct {
+	testPort
+}
+
+func (output *testOutput) Factory() ik.Plugin {
+	return nil
+}
+
+func (output *testOutput) Run() error {
+	return nil
+}
+
+func (output *testOutput) Shutdown() error {
+	return nil
+}
@@ -1,15 +1,21 @@
 package plugins
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"github.com/moriyoshi/ik"
 	"github.com/ugorji/go/codec"
 	"io"
+	"io/ioutil"
+	"math"
 	"net"
-	"reflect"
 	"strconv"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type forwardClient struct {
@@ -21,15 +27,27 @@ type forwardClient struct {
 	dec    *codec.Decoder
 }
 
+// DefaultMaxDecompressedSize is the default limit on the size of a
+// CompressedPackedForward payload after decompression.
+const DefaultMaxDecompressedSize = 64 * 1024 * 1024
+
 type ForwardInput struct {
-	factory  *ForwardInputFactory
-	port     ik.Port
-	logger   ik.Logger
-	bind     string
-	listener net.Listener
-	codec    *codec.MsgpackHandle
-	clients  map[net.Conn]*forwardClient
-	entries  int64
+	factory             *ForwardInputFactory
+	port                ik.Port
+	logger              ik.Logger
+	bind                string
+	listener            net.Listener
+	codec               *codec.MsgpackHandle
+	security            *forwardSecurity
+	clients             map[net.Conn]*forwardClient
+	clientsMtx          sync.Mutex
+	entries             int64
+	maxDecompressedSize int64 // zero means no limit
+}
+
+type forwardOption struct {
+	chunk      string
+	compressed string
 }
 
 type EntryCountTopic struct{}
@@ -50,14 +68,32 @@ func coerceInPlace(data map[string]interface{}) {
 	}
 }
 
-func decodeRecordSet(tag []byte, entries []interface{}) (ik.FluentRecordSet, error) {
+func decodeTimestamp(v interface{}) (time.Time, bool) {
+	switch v_ := v.(type) {
+	case eventTime:
+		return v_.Time(), true
+	case uint64:
+		return time.Unix(int64(v_), 0), true
+	case int64:
+		return time.Unix(v_, 0), true
+	case float64:
+		sec, frac := math.Modf(v_)
+		return time.Unix(int64(sec), int64(frac*1e9)), true
+	}
+	return time.Time{}, false
+}
+
+func decodeRecordSet(tag string, entries []interface{}) (ik.FluentRecordSet, error) {
 	records := make([]ik.TinyFluentRecord, len(entries))
 	for i, _entry := range entries {
 		entry, ok := _entry.([]interface{})
 		if !ok {
 			return ik.FluentRecordSet{}, errors.New("Failed to decode recordSet")
 		}
-		timestamp, ok := entry[0].(uint64)
+		if len(entry) < 2 {
+			return ik.FluentRecordSet{}, errors.New("Failed to decode recordSet")
+		}
+		timestamp, ok := decodeTimestamp(entry[0])
 		if !ok {
 			return ik.FluentRecordSet{}, errors.New("Failed to decode timestamp field")
 		}
@@ -72,34 +108,120 @@ func decodeRecordSet(tag []byte, entries []interface{}) (ik.FluentRecordSet, err
 		}
 	}
 	return ik.FluentRecordSet{
-		Tag:     string(tag), // XXX: byte => rune
+		Tag:     tag,
 		Records: records,
 	}, nil
 }
 
-func (c *forwardClient) decodeEntries() ([]ik.FluentRecordSet, error) {
+func stringify(v interface{}) (string, bool) {
+	switch v_ := v.(type) {
+	case string:
+		return v_, true
+	case []byte:
+		return string(v_), true // XXX: byte => rune
+	}
+	return "", false
+}
+
+func decodeOption(v interface{}) (forwardOption, error) {
+	option := forwardOption{}
+	if v == nil {
+		return option, nil
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return option, errors.New("Failed to decode option field")
+	}
+	chunk, ok := m["chunk"]
+	if ok {
+		option.chunk, ok = stringify(chunk)
+		if !ok {
+			return option, errors.New("Failed to decode chunk option")
+		}
+	}
+	compressed, ok := m["compressed"]
+	if ok {
+		option.compressed, ok = stringify(compressed)
+		if !ok {
+			return option, errors.New("Failed to decode compressed option")
+		}
+	}
+	return option, nil
+}
+
+// PackedForward entries are a concatenation of msgpack-encoded
+// [time, record] pairs, which may be gzip'ed as a whole
+// (CompressedPackedForward).  The payload that decompresses to more than
+// maxDecompressedSize bytes is rejected unless maxDecompressedSize is zero.
+func decodePackedEntries(_codec *codec.MsgpackHandle, packed []byte, compressed string, maxDecompressedSize int64) ([]interface{}, error) {
+	switch compressed {
+	case "", "text":
+		break
+	case "gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(packed))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		if maxDecompressedSize > 0 {
+			packed, err = ioutil.ReadAll(io.LimitReader(reader, maxDecompressedSize+1))
+			if err == nil && int64(len(packed)) > maxDecompressedSize {
+				err = errors.New(fmt.Sprintf("Decompressed payload exceeds %d bytes", maxDecompressedSize))
+			}
+		} else {
+			packed, err = ioutil.ReadAll(reader)
+		}
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New(fmt.Sprintf("Unsupported compression: %s", compressed))
+	}
+	entries := make([]interface{}, 0)
+	reader := bytes.NewReader(packed)
+	dec := codec.NewDecoder(reader, _codec)
+	for reader.Len() > 0 {
+		entry := make([]interface{}, 0, 2)
+		err := dec.Decode(&entry)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (c *forwardClient) decodeEntries() ([]ik.FluentRecordSet, forwardOption, error) {
 	v := []interface{}{nil, nil, nil}
 	err := c.dec.Decode(&v)
 	if err != nil {
-		return nil, err
+		return nil, forwardOption{}, err
+	}
+	if len(v) < 2 {
+		return nil, forwardOption{}, errors.New("Unexpected payload format")
 	}
-	tag, ok := v[0].([]byte)
+	tag, ok := stringify(v[0])
 	if !ok {
-		return nil, errors.New("Failed to decode tag field")
+		return nil, forwardOption{}, errors.New("Failed to decode tag field")
 	}
 
 	var retval []ik.FluentRecordSet
+	var optionField interface{}
+	var packed []byte
 	switch timestamp_or_entries := v[1].(type) {
-	case uint64:
-		timestamp := timestamp_or_entries
+	case eventTime, uint64, int64, float64:
+		timestamp, _ := decodeTimestamp(timestamp_or_entries)
+		if len(v) < 3 {
+			return nil, forwardOption{}, errors.New("Unexpected payload format")
+		}
 		data, ok := v[2].(map[string]interface{})
 		if !ok {
-			return nil, errors.New(fmt.Sprintf("Failed to decode data field (got %t)", v[2]))
+			return nil, forwardOption{}, errors.New(fmt.Sprintf("Failed to decode data field (got %t)", v[2]))
 		}
 		coerceInPlace(data)
 		retval = []ik.FluentRecordSet{
 			{
-				Tag: string(tag), // XXX: byte => rune
+				Tag: tag,
 				Records: []ik.TinyFluentRecord{
 					{
 						Timestamp: timestamp,
@@ -108,60 +230,70 @@ func (c *forwardClient) decodeEntries() ([]ik.FluentRecordSet, error) {
 				},
 			},
 		}
-	case float64:
-		timestamp := uint64(timestamp_or_entries)
-		data, ok := v[2].(map[string]interface{})
-		if !ok {
-			return nil, errors.New(fmt.Sprintf("Failed to decode data field (got %t)", v[2]))
-		}
-		retval = []ik.FluentRecordSet{
-			{
-				Tag: string(tag), // XXX: byte => rune
-				Records: []ik.TinyFluentRecord{
-					{
-						Timestamp: timestamp,
-						Data:      data,
-					},
-				},
-			},
+		if len(v) > 3 {
+			optionField = v[3]
 		}
 	case []interface{}:
-		if !ok {
-			return nil, errors.New("Unexpected payload format")
-		}
 		recordSet, err := decodeRecordSet(tag, timestamp_or_entries)
 		if err != nil {
-			return nil, err
+			return nil, forwardOption{}, err
 		}
 		retval = []ik.FluentRecordSet{recordSet}
+		if len(v) > 2 {
+			optionField = v[2]
+		}
 	case []byte:
-		entries := make([]interface{}, 0)
-		err := codec.NewDecoderBytes(timestamp_or_entries, c.codec).Decode(&entries)
+		packed = timestamp_or_entries
+		if len(v) > 2 {
+			optionField = v[2]
+		}
+	case string:
+		packed = []byte(timestamp_or_entries)
+		if len(v) > 2 {
+			optionField = v[2]
+		}
+	default:
+		return nil, forwardOption{}, errors.New(fmt.Sprintf("Unknown type: %t", timestamp_or_entries))
+	}
+	option, err := decodeOption(optionField)
+	if err != nil {
+		return nil, forwardOption{}, err
+	}
+	if packed != nil {
+		entries, err := decodePackedEntries(c.codec, packed, option.compressed, c.input.maxDecompressedSize)
 		if err != nil {
-			return nil, err
+			return nil, forwardOption{}, err
 		}
 		recordSet, err := decodeRecordSet(tag, entries)
 		if err != nil {
-			return nil, err
+			return nil, forwardOption{}, err
 		}
 		retval = []ik.FluentRecordSet{recordSet}
-	default:
-		return nil, errors.New(fmt.Sprintf("Unknown type: %t", timestamp_or_entries))
 	}
 	atomic.AddInt64(&c.input.entries, int64(len(retval)))
-	return retval, nil
+	return retval, option, nil
+}
+
+func (c *forwardClient) sendAck(chunk string) error {
+	return c.enc.Encode(map[string]interface{}{"ack": chunk})
 }
 
 func handleInner(c *forwardClient) bool {
-	recordSets, err := c.decodeEntries()
-	defer func() {
-		if len(recordSets) > 0 {
-			err_ := c.input.Port().Emit(recordSets)
+	recordSets, option, err := c.decodeEntries()
+	if len(recordSets) > 0 {
+		err_ := c.input.Port().Emit(recordSets)
+		if err_ != nil {
+			c.logger.Error("%s", err_.Error())
+		} else if option.chunk != "" {
+			// the client is waiting for the acknowledgement only after
+			// the entries have been successfully handed over to the port
+			err_ = c.sendAck(option.chunk)
 			if err_ != nil {
-				c.logger.Error("%s", err_.Error())
+				c.logger.Error("Failed to send ack to %s: %s", c.conn.RemoteAddr().String(), err_.Error())
+				return false
 			}
 		}
-	}()
+	}
 	if err == nil {
 		return true
 	}
@@ -182,7 +314,15 @@ func handleInner(c *forwardClient) bool {
 }
 
 func (c *forwardClient) handle() {
-	for handleInner(c) {
+	authenticated := true
+	if c.input.security != nil {
+		err := c.input.security.serverHandshake(c.enc, c.dec)
+		if err != nil {
+			c.logger.Error("Handshake with %s failed: %s", c.conn.RemoteAddr().String(), err.Error())
+			authenticated = false
+		}
+	}
+	for authenticated && handleInner(c) {
 	}
 	err := c.conn.Close()
 	if err != nil {
@@ -223,7 +363,14 @@ func (input *ForwardInput) Run() error {
 }
 
 func (input *ForwardInput) Shutdown() error {
+	// the connections are removed from the map as they get closed
+	input.clientsMtx.Lock()
+	conns := make([]net.Conn, 0, len(input.clients))
 	for conn, _ := range input.clients {
+		conns = append(conns, conn)
+	}
+	input.clientsMtx.Unlock()
+	for _, conn := range conns {
 		err := conn.Close()
 		if err != nil {
 			input.logger.Warning("Error during closing connection: %s", err.Error())
@@ -237,31 +384,45 @@ func (input *ForwardInput) Dispose() {
 }
 
 func (input *ForwardInput) markCharged(c *forwardClient) {
+	input.clientsMtx.Lock()
+	defer input.clientsMtx.Unlock()
 	input.clients[c.conn] = c
 }
 
 func (input *ForwardInput) markDischarged(c *forwardClient) {
+	input.clientsMtx.Lock()
+	defer input.clientsMtx.Unlock()
 	delete(input.clients, c.conn)
 }
 
-func newForwardInput(factory *ForwardInputFactory, logger ik.Logger, engine ik.Engine, bind string, port ik.Port) (*ForwardInput, error) {
-	_codec := codec.MsgpackHandle{}
-	_codec.MapType = reflect.TypeOf(map[string]interface{}(nil))
-	_codec.RawToString = false
+func (input *ForwardInput) clientCount() int {
+	input.clientsMtx.Lock()
+	defer input.clientsMtx.Unlock()
+	return len(input.clients)
+}
+
+func newForwardInput(factory *ForwardInputFactory, logger ik.Logger, engine ik.Engine, bind string, port ik.Port, security *forwardSecurity, tlsConfig *tls.Config) (*ForwardInput, error) {
+	_codec := newForwardCodec()
 	listener, err := net.Listen("tcp", bind)
 	if err != nil {
 		logger.Warning("%s", err.Error())
 		return nil, err
 	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
 	return &ForwardInput{
 		factory:  factory,
 		port:     port,
 		logger:   logger,
 		bind:     bind,
 		listener: listener,
-		codec:    &_codec,
+		codec:    _codec,
+		security: security,
 		clients:  make(map[net.Conn]*forwardClient),
 		entries:  0,
+		// overridden by max_decompressed_size
+		maxDecompressedSize: DefaultMaxDecompressedSize,
 	}, nil
 }
 
@@ -279,7 +440,28 @@ func (factory *ForwardInputFactory) New(engine ik.Engine, config *ik.ConfigEleme
 		netPort = "24224"
 	}
 	bind := listen + ":" + netPort
-	return newForwardInput(factory, engine.Logger(), engine, bind, engine.DefaultPort())
+	security, err := parseForwardSecurity(config)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := newServerTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	maxDecompressedSize := int64(DefaultMaxDecompressedSize)
+	maxDecompressedSizeStr, ok := config.Attrs["max_decompressed_size"]
+	if ok {
+		maxDecompressedSize, err = strconv.ParseInt(maxDecompressedSizeStr, 10, 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to parse max_decompressed_size: %s", err.Error()))
+		}
+	}
+	input, err := newForwardInput(factory, engine.Logger(), engine, bind, engine.DefaultPort(), security, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	input.maxDecompressedSize = maxDecompressedSize
+	return input, nil
 }
 
 func (factory *ForwardInputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
@@ -322,7 +504,7 @@ func (topic *ConnectionCountTopic) Markup(input_ ik.PluginInstance) (ik.Markup,
 
 func (topic *ConnectionCountTopic) PlainText(input_ ik.PluginInstance) (string, error) {
 	input := input_.(*ForwardInput)
-	return strconv.Itoa(len(input.clients)), nil // XXX: race
+	return strconv.Itoa(input.clientCount()), nil
 }
 
 var _ = AddPlugin(&ForwardInputFactory{})
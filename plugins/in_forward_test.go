@@ -0,0 +1,153 @@
+package plugins
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/moriyoshi/ik"
+	"github.com/ugorji/go/codec"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestForwardClient(t *testing.T, port ik.Port) (*forwardClient, net.Conn) {
+	server, client := net.Pipe()
+	input := &ForwardInput{
+		factory: &ForwardInputFactory{},
+		port:    port,
+		logger:  &testLogger{t},
+		codec:   newForwardCodec(),
+		clients: make(map[net.Conn]*forwardClient),
+	}
+	return newForwardClient(input, input.logger, server, input.codec), client
+}
+
+func TestForwardInput_ack(t *testing.T) {
+	port := &testPort{}
+	c, conn := newTestForwardClient(t, port)
+	defer conn.Close()
+	go func() {
+		enc := codec.NewEncoder(conn, newForwardCodec())
+		enc.Encode([]interface{}{
+			"test.tag",
+			[]interface{}{
+				[]interface{}{uint64(1), map[string]interface{}{"a": "b"}},
+			},
+			map[string]interface{}{"chunk": "Y2h1bms="},
+		})
+	}()
+	go handleInner(c)
+	response := map[string]interface{}{}
+	err := codec.NewDecoder(conn, newForwardCodec()).Decode(&response)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	chunk, _ := stringify(response["ack"])
+	if chunk != "Y2h1bms=" {
+		t.Logf("%#v", response)
+		t.Fail()
+	}
+	if len(port.recordSets) != 1 || port.recordSets[0].Tag != "test.tag" {
+		t.Fail()
+	}
+}
+
+func TestForwardInput_compressedPackedForward(t *testing.T) {
+	output := &ForwardOutput{
+		logger:            &testLogger{t},
+		codec:             newForwardCodec(),
+		compressionFormat: compressionGzip,
+	}
+	packer := &ForwardOutputPacker{output}
+	entries := []byte{}
+	for _, record := range []ik.FluentRecord{
+		{Tag: "test.tag", Timestamp: time.Unix(1, 0), Data: map[string]interface{}{"a": "b"}},
+		{Tag: "test.tag", Timestamp: time.Unix(2, 500), Data: map[string]interface{}{"c": "d"}},
+	} {
+		entry, err := packer.Pack(record)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		entries = append(entries, entry...)
+	}
+	payload, err := output.encodeChunk("test.tag", entries, "")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	port := &testPort{}
+	c, conn := newTestForwardClient(t, port)
+	defer conn.Close()
+	go conn.Write(payload)
+	if !handleInner(c) {
+		t.FailNow()
+	}
+	if len(port.recordSets) != 1 {
+		t.FailNow()
+	}
+	recordSet := port.recordSets[0]
+	if recordSet.Tag != "test.tag" || len(recordSet.Records) != 2 {
+		t.Fail()
+	}
+	if !recordSet.Records[1].Timestamp.Equal(time.Unix(2, 500)) || recordSet.Records[1].Data["c"] != "d" {
+		t.Logf("%#v", recordSet.Records[1])
+		t.Fail()
+	}
+}
+
+func TestForwardInput_eventTime(t *testing.T) {
+	port := &testPort{}
+	c, conn := newTestForwardClient(t, port)
+	defer conn.Close()
+	go func() {
+		enc := codec.NewEncoder(conn, newForwardCodec())
+		enc.Encode([]interface{}{
+			"test.tag",
+			newEventTime(time.Unix(1400000000, 123456789)),
+			map[string]interface{}{"a": "b"},
+		})
+		enc.Encode([]interface{}{
+			"test.tag",
+			float64(1400000000.5),
+			map[string]interface{}{"a": "b"},
+		})
+	}()
+	if !handleInner(c) || !handleInner(c) {
+		t.FailNow()
+	}
+	if len(port.recordSets) != 2 {
+		t.FailNow()
+	}
+	timestamp := port.recordSets[0].Records[0].Timestamp
+	if timestamp.Unix() != 1400000000 || timestamp.Nanosecond() != 123456789 {
+		t.Log(timestamp)
+		t.Fail()
+	}
+	timestamp = port.recordSets[1].Records[0].Timestamp
+	if timestamp.Unix() != 1400000000 || timestamp.Nanosecond() != 500000000 {
+		t.Log(timestamp)
+		t.Fail()
+	}
+}
+
+func TestForwardInput_decompressionLimit(t *testing.T) {
+	_codec := newForwardCodec()
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	enc := codec.NewEncoder(w, _codec)
+	for i := 0; i < 100; i += 1 {
+		enc.Encode([]interface{}{uint64(1), map[string]interface{}{"a": strings.Repeat("b", 100)}})
+	}
+	w.Close()
+	entries, err := decodePackedEntries(_codec, buf.Bytes(), "gzip", 1024*1024)
+	if err != nil || len(entries) != 100 {
+		t.Fail()
+	}
+	_, err = decodePackedEntries(_codec, buf.Bytes(), "gzip", 1024)
+	if err == nil {
+		t.Fail()
+	}
+}
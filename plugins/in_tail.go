@@ -5,13 +5,17 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/howeyc/fsnotify"
 	fileid "github.com/moriyoshi/go-fileid"
 	"github.com/moriyoshi/ik"
 	"io"
+	"io/ioutil"
+	"math"
 	"net/http"
 	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -42,6 +46,8 @@ func (b *MyBufferedReader) fillUp() (bool, error) {
 		return false, nil
 	}
 	n, err := b.inner.Read(b.b[b.w:])
+	// some readers return the last bytes together with io.EOF
+	b.w += n
 	if err != nil {
 		if err == io.EOF {
 			b.eofReached = true
@@ -50,7 +56,6 @@ func (b *MyBufferedReader) fillUp() (bool, error) {
 			return false, err
 		}
 	}
-	b.w += n
 	return true, nil
 }
 
@@ -145,9 +150,71 @@ type TailEventHandler struct {
 	decoder        func([]byte) (string, error)
 	stateSaver     func(target TailTarget, position int64) error
 	lineReceiver   func(line string) error
+	multiline      *TailMultilineBuffer
 	closer         func() error
 }
 
+// TailMultilineBuffer puts together the lines of an event, which begins
+// with a line that matches format_firstline.  The position of the pending
+// event is what gets saved, so that the event is read again from its first
+// line after restart.
+type TailMultilineBuffer struct {
+	firstline     *regexp.Regexp
+	flushInterval time.Duration
+	lines         []string
+	position      int64
+	updated       time.Time
+	receiver      func(lines string) error
+}
+
+func (buffer *TailMultilineBuffer) Feed(line string, position int64, now time.Time) error {
+	if buffer.firstline.MatchString(line) {
+		err := buffer.Flush()
+		buffer.lines = append(buffer.lines, line)
+		buffer.position = position
+		buffer.updated = now
+		return err
+	}
+	if len(buffer.lines) == 0 {
+		// no event to continue
+		return buffer.receiver(line)
+	}
+	buffer.lines = append(buffer.lines, line)
+	buffer.updated = now
+	return nil
+}
+
+func (buffer *TailMultilineBuffer) Flush() error {
+	if len(buffer.lines) == 0 {
+		return nil
+	}
+	lines := strings.Join(buffer.lines, "\n")
+	buffer.lines = buffer.lines[:0]
+	return buffer.receiver(lines)
+}
+
+func (buffer *TailMultilineBuffer) Pending() bool {
+	return len(buffer.lines) > 0
+}
+
+// Position returns the position of the first line of the pending event.
+func (buffer *TailMultilineBuffer) Position() int64 {
+	return buffer.position
+}
+
+func (buffer *TailMultilineBuffer) Expired(now time.Time) bool {
+	return buffer.flushInterval > 0 && len(buffer.lines) > 0 && now.Sub(buffer.updated) >= buffer.flushInterval
+}
+
+func NewTailMultilineBuffer(firstline *regexp.Regexp, flushInterval time.Duration, receiver func(lines string) error) *TailMultilineBuffer {
+	return &TailMultilineBuffer{
+		firstline:     firstline,
+		flushInterval: flushInterval,
+		lines:         make([]string, 0, 16),
+		receiver:      receiver,
+	}
+}
+
 func openTarget(path string) (TailTarget, error) {
 	f, err := os.OpenFile(path, os.O_RDONLY, 0)
 	if err != nil {
@@ -200,8 +267,9 @@ func (handler *TailEventHandler) decode(in []byte) (string, error) {
 	}
 }
 
-func (handler *TailEventHandler) fetch() error {
+func (handler *TailEventHandler) fetch(now time.Time) error {
 	for {
+		position := handler.bf.position
 		line, ispfx, tryAgain, err := handler.bf.ReadLine()
 		if err != nil {
 			if err == io.EOF {
@@ -230,7 +298,11 @@ func (handler *TailEventHandler) fetch() error {
 			handler.logger.Error("failed to decode line")
 			stringizedLine = hex.Dump(line)
 		}
-		err = handler.lineReceiver(stringizedLine)
+		if handler.multiline != nil {
+			err = handler.multiline.Feed(stringizedLine, position, now)
+		} else {
+			err = handler.lineReceiver(stringizedLine)
+		}
 		if err != nil {
 			return err
 		}
@@ -239,9 +311,13 @@ func (handler *TailEventHandler) fetch() error {
 }
 
 func (handler *TailEventHandler) saveState() error {
+	position := handler.bf.position
+	if handler.multiline != nil && handler.multiline.Pending() {
+		position = handler.multiline.Position()
+	}
 	return handler.stateSaver(
 		handler.target,
-		handler.bf.position,
+		position,
 	)
 }
 
@@ -321,6 +397,13 @@ func (handler *TailEventHandler) OnChange(now time.Time) error {
 	if target.f != handler.target.f || target.size < handler.target.size {
 		// file was replaced / moved / created / truncated
 		newPosition := target.size
+		if handler.multiline != nil {
+			// the rest of the event never comes
+			err = handler.multiline.Flush()
+			if err != nil {
+				return err
+			}
+		}
 		if target.f != handler.target.f {
 			if handler.target.f != nil {
 				err = handler.target.f.Close()
@@ -341,11 +424,22 @@ func (handler *TailEventHandler) OnChange(now time.Time) error {
 	}
 	handler.target = target
 
+	saveNeeded := fetchNeeded
 	if fetchNeeded {
-		err = handler.fetch()
+		err = handler.fetch(now)
+		if err != nil {
+			return err
+		}
+	}
+	if handler.multiline != nil && handler.multiline.Expired(now) {
+		// no more lines arrived within the flush interval
+		err = handler.multiline.Flush()
 		if err != nil {
 			return err
 		}
+		saveNeeded = true
+	}
+	if saveNeeded {
 		err = handler.saveState()
 		if err != nil {
 			return err
@@ -354,6 +448,19 @@ func (handler *TailEventHandler) OnChange(now time.Time) error {
 	return nil
 }
 
+// OnTimer flushes the pending multiline event once it has expired.  This
+// is the only thing done on ticks when the watch timer is disabled.
+func (handler *TailEventHandler) OnTimer(now time.Time) error {
+	if handler.multiline == nil || !handler.multiline.Expired(now) {
+		return nil
+	}
+	err := handler.multiline.Flush()
+	if err != nil {
+		return err
+	}
+	return handler.saveState()
+}
+
 func NewTailEventHandler(
 	logger ik.Logger,
 	target TailTarget,
@@ -363,6 +470,7 @@ func NewTailEventHandler(
 	decoder func([]byte) (string, error),
 	stateSaver func(target TailTarget, position int64) error,
 	lineReceiver func(line string) error,
+	multiline *TailMultilineBuffer,
 	closer func() error,
 ) (*TailEventHandler, error) {
 	bf := (*MyBufferedReader)(nil)
@@ -389,6 +497,7 @@ func NewTailEventHandler(
 		decoder:        decoder,
 		stateSaver:     stateSaver,
 		lineReceiver:   lineReceiver,
+		multiline:      multiline,
 		closer:         closer,
 	}, nil
 }
@@ -415,6 +524,7 @@ type TailPositionFileEntry struct {
 	positionFile *TailPositionFile
 	offset       int
 	isNew        bool
+	dead         bool // removed from the file while still in use
 	data         tailPositionFileData
 }
 
@@ -422,11 +532,13 @@ type TailPositionFile struct {
 	logger      ik.Logger
 	refcount    int64
 	path        string
-	f           *os.File
 	view        []byte
 	entries     map[string]*TailPositionFileEntry
 	controlChan chan bool
+	stopped     chan struct{}
+	dirty       bool // there are updates that have not been written
 	mtx         sync.Mutex
+	saveMtx     sync.Mutex // serializes the writes to the temporary file
 }
 
 type concreateTailFileInfo struct {
@@ -659,31 +771,40 @@ func (entry *TailPositionFileEntry) deleteRef() error {
 
 func (positionFile *TailPositionFile) scheduleUpdate(entry *TailPositionFileEntry) error {
 	blob := marshalPositionFileData(&entry.data)
+	positionFile.mtx.Lock()
+	if entry.dead {
+		// the offset no longer belongs to the entry
+		positionFile.mtx.Unlock()
+		return nil
+	}
 	offset := entry.offset
 	copy(positionFile.view[offset:offset+len(blob)], blob)
-	positionFile.controlChan <- false
+	positionFile.dirty = true
+	positionFile.mtx.Unlock()
+	select {
+	case positionFile.controlChan <- false:
+	default:
+		// the update that is already scheduled covers this one
+	}
 	return nil
 }
 
 func (positionFile *TailPositionFile) doUpdate() {
+	defer close(positionFile.stopped)
 	for needsToBeStopped := range positionFile.controlChan {
+		positionFile.mtx.Lock()
+		dirty := positionFile.dirty
+		positionFile.mtx.Unlock()
+		// the file is left untouched unless something was changed
+		if dirty {
+			err := positionFile.save()
+			if err != nil {
+				positionFile.logger.Error("failed to update position file %s: %s", positionFile.path, err.Error())
+			}
+		}
 		if needsToBeStopped {
 			break
 		}
-		_, err := positionFile.f.Seek(0, os.SEEK_SET)
-		if err != nil {
-			positionFile.logger.Error("%s", err.Error())
-			continue
-		}
-		n, err := positionFile.f.Write(positionFile.view)
-		if err != nil {
-			positionFile.logger.Error("%s", err.Error())
-			continue
-		}
-		if n != len(positionFile.view) {
-			positionFile.logger.Error("failed to update position file: %s", positionFile.path)
-			continue
-		}
 	}
 }
 
@@ -714,29 +835,109 @@ func (positionFile *TailPositionFile) Get(path string) TailFileInfo {
 	return newConcreteTailFileInfo(entry)
 }
 
-func (positionFile *TailPositionFile) Dispose() error {
-	return positionFile.deleteRef()
+// Paths returns the paths that have entries, in the order of appearance.
+func (positionFile *TailPositionFile) Paths() []string {
+	positionFile.mtx.Lock()
+	defer positionFile.mtx.Unlock()
+	entries := positionFile.sortedEntries()
+	retval := make([]string, len(entries))
+	for i, entry := range entries {
+		retval[i] = entry.data.Path
+	}
+	return retval
 }
 
-func readTailPositionFileEntries(positionFile *TailPositionFile, f *os.File) (map[string]*TailPositionFileEntry, error) {
-	retval := make(map[string]*TailPositionFileEntry)
-	_, err := f.Seek(0, os.SEEK_SET)
-	if err != nil {
-		return nil, err
+func (positionFile *TailPositionFile) sortedEntries() []*TailPositionFileEntry {
+	entries := make([]*TailPositionFileEntry, 0, len(positionFile.entries))
+	for _, entry := range positionFile.entries {
+		entries = append(entries, entry)
 	}
-	info, err := f.Stat()
-	if err != nil {
-		return nil, err
+	sort.Slice(entries, func(i, j int) bool { return entries[i].offset < entries[j].offset })
+	return entries
+}
+
+// retain drops the entries for which keep returns false and packs the
+// rest.  The dropped entries are marked dead so that the updates through
+// the TailFileInfos still referring to them are ignored.
+func (positionFile *TailPositionFile) retain(keep func(entry *TailPositionFileEntry) bool) int {
+	positionFile.mtx.Lock()
+	defer positionFile.mtx.Unlock()
+	view := make([]byte, 0, len(positionFile.view))
+	removed := 0
+	for _, entry := range positionFile.sortedEntries() {
+		if !keep(entry) {
+			delete(positionFile.entries, entry.data.Path)
+			entry.dead = true
+			removed += 1
+			continue
+		}
+		entry.offset = len(view)
+		view = append(view, marshalPositionFileData(&entry.data)...)
 	}
-	blob := make([]byte, int(info.Size()))
-	n, err := f.Read(blob)
-	if err != nil {
-		return nil, err
+	positionFile.view = view
+	return removed
+}
+
+// Compact drops the entries of the files that no longer exist and returns
+// the number of the dropped entries.
+func (positionFile *TailPositionFile) Compact() (int, error) {
+	removed := positionFile.retain(func(entry *TailPositionFileEntry) bool {
+		_, err := os.Stat(entry.data.Path)
+		return !os.IsNotExist(err)
+	})
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, positionFile.save()
+}
+
+// Remove forgets the path so that the file is regarded as new next time.
+func (positionFile *TailPositionFile) Remove(path string) (bool, error) {
+	removed := positionFile.retain(func(entry *TailPositionFileEntry) bool {
+		return entry.data.Path != path
+	})
+	if removed == 0 {
+		return false, nil
+	}
+	return true, positionFile.save()
+}
+
+// TailPositionCompleted is recorded as the position of the file that has
+// been read to the end and must not be read again, like a compressed one.
+const TailPositionCompleted = int64(math.MaxInt64)
+
+// IsCompleted tells whether the file has been read through.  The entry
+// for the path is looked up first, and the file is looked up by the id
+// only among the entries whose paths no longer exist, which suggests that
+// the file was renamed.  The id alone may belong to another file, as
+// inode numbers are reused once the files are deleted.
+func (positionFile *TailPositionFile) IsCompleted(path string, id fileid.FileId) bool {
+	positionFile.mtx.Lock()
+	defer positionFile.mtx.Unlock()
+	entry, ok := positionFile.entries[path]
+	if ok && fileid.IsSame(entry.data.Id, id) {
+		return entry.data.Position == TailPositionCompleted
 	}
-	if n != len(blob) {
-		return nil, errors.New("unexpected EOF")
+	for _, entry := range positionFile.entries {
+		if entry.data.Position != TailPositionCompleted || !fileid.IsSame(entry.data.Id, id) {
+			continue
+		}
+		_, err := os.Stat(entry.data.Path)
+		if os.IsNotExist(err) {
+			return true
+		}
 	}
+	return false
+}
+
+func (positionFile *TailPositionFile) Dispose() error {
+	return positionFile.deleteRef()
+}
+
+func readTailPositionFileEntries(positionFile *TailPositionFile, blob []byte) (map[string]*TailPositionFileEntry, error) {
+	retval := make(map[string]*TailPositionFileEntry)
 	consumed := 0
+	err := (error)(nil)
 	for offset := 0; offset < len(blob); offset += consumed {
 		entry := &TailPositionFileEntry{
 			positionFile: positionFile,
@@ -754,57 +955,88 @@ func readTailPositionFileEntries(positionFile *TailPositionFile, f *os.File) (ma
 	return retval, nil
 }
 
-func openPositionFile(logger ik.Logger, path string) (*TailPositionFile, error) {
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666) // FIXME
-	failed := true
-	defer func() {
-		if failed && f != nil {
-			f.Close()
-		}
-	}()
-	var entries map[string]*TailPositionFileEntry
+func OpenTailPositionFile(logger ik.Logger, path string) (*TailPositionFile, error) {
 	retval := &TailPositionFile{
 		logger:      logger,
 		refcount:    1,
 		path:        path,
-		controlChan: make(chan bool),
+		controlChan: make(chan bool, 1),
+		stopped:     make(chan struct{}),
 		mtx:         sync.Mutex{},
 	}
+	blob, err := ioutil.ReadFile(path)
 	if err != nil {
-		if !os.IsExist(err) {
+		if !os.IsNotExist(err) {
 			return nil, err
 		}
-		f, err = os.OpenFile(path, os.O_RDWR, 0666)
-		if err != nil {
-			return nil, err
-		}
-		entries, err = readTailPositionFileEntries(retval, f)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		entries = make(map[string]*TailPositionFileEntry)
+		blob = []byte{}
+	}
+	entries, err := readTailPositionFileEntries(retval, blob)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %s", path, err.Error()))
 	}
-	retval.f = f
 	retval.entries = entries
-	failed = false
 	go retval.doUpdate()
 	return retval, nil
 }
 
+// save writes the entries to a temporary file and renames it over the
+// position file, so that the file is never left torn by a crash.
 func (positionFile *TailPositionFile) save() error {
-	_, err := positionFile.f.Seek(0, os.SEEK_SET)
+	positionFile.saveMtx.Lock()
+	defer positionFile.saveMtx.Unlock()
+	positionFile.mtx.Lock()
+	view := make([]byte, len(positionFile.view))
+	copy(view, positionFile.view)
+	positionFile.dirty = false
+	positionFile.mtx.Unlock()
+
+	err := positionFile.write(view)
+	if err != nil {
+		// tried again on the next update
+		positionFile.mtx.Lock()
+		positionFile.dirty = true
+		positionFile.mtx.Unlock()
+	}
+	return err
+}
+
+func (positionFile *TailPositionFile) write(view []byte) error {
+	tempPath := positionFile.path + ".tmp"
+	f, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		return err
 	}
-	l, err := positionFile.f.Write(positionFile.view)
+	l, err := f.Write(view)
+	if err == nil && l != len(view) {
+		err = errors.New("marshalled data not fully written")
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	err_ := f.Close()
+	if err == nil {
+		err = err_
+	}
 	if err != nil {
+		os.Remove(tempPath)
 		return err
 	}
-	if l != len(positionFile.view) {
-		return errors.New("marshalled data not fully written")
+	err = os.Rename(tempPath, positionFile.path)
+	if err != nil {
+		return err
 	}
-	return nil
+	// the rename itself is persisted by syncing the directory
+	dir, err := os.Open(filepath.Dir(positionFile.path))
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	err_ = dir.Close()
+	if err == nil {
+		err = err_
+	}
+	return err
 }
 
 func (positionFile *TailPositionFile) addRef() {
@@ -814,14 +1046,10 @@ func (positionFile *TailPositionFile) addRef() {
 func (positionFile *TailPositionFile) deleteRef() error {
 	positionFile.refcount -= 1
 	if positionFile.refcount == 0 {
-		err := positionFile.f.Close()
-		if err != nil {
-			positionFile.refcount += 1 // undo the change
-			return err
-		}
-		positionFile.f = nil
 		positionFile.controlChan <- true
 		close(positionFile.controlChan)
+		// wait for the last update to be written
+		<-positionFile.stopped
 	} else if positionFile.refcount < 0 {
 		panic("refcount < 0!")
 	}
@@ -831,22 +1059,34 @@ func (positionFile *TailPositionFile) deleteRef() error {
 type TailInputFactory struct {
 }
 
+// tailStatWatcher notifies the changes of the files instead of polling
+// them.  It is only available on Linux, where inotify is used.
+type tailStatWatcher interface {
+	Watch(path string, notify func()) error
+	Unwatch(path string) error
+	// WatchDir notifies the creation of the entries in the directory.
+	WatchDir(dir string, notify func()) error
+	Close() error
+}
+
 type TailWatcher struct {
 	input          *TailInput
 	synthesizedTag string
+	tag            string
 	positionFile   *TailPositionFile
 	tailFileInfo   TailFileInfo
 	lineParser     ik.LineParser
 	handler        *TailEventHandler
-	statWatcher    *fsnotify.Watcher
+	watching       bool
+	changeChan     chan struct{}
 	timer          *time.Ticker
 	controlChan    chan bool
 }
 
 func (watcher *TailWatcher) cleanup() {
-	if watcher.statWatcher != nil {
-		watcher.statWatcher.Close()
-		watcher.statWatcher = nil
+	if watcher.watching {
+		watcher.input.statWatcher.Unwatch(watcher.tailFileInfo.Path())
+		watcher.watching = false
 	}
 	if watcher.handler != nil {
 		watcher.handler.Dispose()
@@ -858,12 +1098,22 @@ func (watcher *TailWatcher) cleanup() {
 	}
 }
 
+func (watcher *TailWatcher) notifyChange() {
+	select {
+	case watcher.changeChan <- struct{}{}:
+	default:
+		// a notification is already pending
+	}
+}
+
 func (watcher *TailWatcher) Run() error {
+	var timerChan <-chan time.Time
+	if watcher.timer != nil {
+		timerChan = watcher.timer.C
+	}
 	for {
 		select {
-		case err := <-watcher.statWatcher.Error:
-			watcher.input.logger.Error("%s", err.Error())
-		case <-watcher.statWatcher.Event:
+		case <-watcher.changeChan:
 			now := time.Now()
 			err := watcher.handler.OnChange(now)
 			if err != nil {
@@ -871,8 +1121,13 @@ func (watcher *TailWatcher) Run() error {
 				return err
 			}
 			return ik.Continue
-		case now := <-watcher.timer.C:
-			err := watcher.handler.OnChange(now)
+		case now := <-timerChan:
+			var err error
+			if watcher.input.enableWatchTimer {
+				err = watcher.handler.OnChange(now)
+			} else {
+				err = watcher.handler.OnTimer(now)
+			}
 			if err != nil {
 				watcher.input.logger.Error("%s", err.Error())
 				return err
@@ -880,7 +1135,8 @@ func (watcher *TailWatcher) Run() error {
 			return ik.Continue
 		case needsToBeStopped := <-watcher.controlChan:
 			if needsToBeStopped {
-				break
+				watcher.cleanup()
+				return nil
 			}
 			now := time.Now()
 			err := watcher.handler.OnChange(now)
@@ -891,8 +1147,6 @@ func (watcher *TailWatcher) Run() error {
 			return ik.Continue
 		}
 	}
-	watcher.cleanup()
-	return nil
 }
 
 func (watcher *TailWatcher) Shutdown() error {
@@ -901,7 +1155,46 @@ func (watcher *TailWatcher) Shutdown() error {
 }
 
 func (watcher *TailWatcher) parseLine(line string) error {
-	return watcher.lineParser.Feed(line)
+	return watcher.input.feedLine(watcher.lineParser, watcher.tag, line)
+}
+
+// feedLine feeds the line to the parser.  The line that cannot be parsed
+// goes to the error stream.
+func (input *TailInput) feedLine(lineParser ik.LineParser, tag string, line string) error {
+	err := lineParser.Feed(line)
+	if err != nil {
+		parseError, ok := err.(*ik.ParseError)
+		if !ok {
+			return err
+		}
+		err = input.engine.ErrorStream().EmitErrorLine(tag, line, parseError)
+		if err != nil {
+			input.logger.Error("%s", err.Error())
+		}
+	}
+	return nil
+}
+
+func (input *TailInput) tagForPath(path string) string {
+	if input.expandTag {
+		// "*" in the tag is replaced by the path of the file
+		return input.tagPrefix + buildTagFromPath(path) + input.tagSuffix
+	}
+	return input.tagPrefix
+}
+
+func (input *TailInput) newLineParser(path string, tag string) (ik.LineParser, error) {
+	return input.lineParserFactory.New(func(record ik.FluentRecord) error {
+		record.Tag = tag
+		if record.Timestamp.IsZero() {
+			record.Timestamp = time.Now()
+		}
+		if input.pathKey != "" {
+			record.Data[input.pathKey] = path
+		}
+		input.pump.EmitOne(record)
+		return nil
+	})
 }
 
 func buildTagFromPath(path string) string {
@@ -954,6 +1247,17 @@ func (input *TailInput) openTailWatcher(path string) (*TailWatcher, error) {
 	watcher := &TailWatcher{
 		input:          input,
 		synthesizedTag: buildTagFromPath(path),
+		tag:            input.tagForPath(path),
+	}
+	multiline := (*TailMultilineBuffer)(nil)
+	if input.formatFirstline != nil {
+		multiline = NewTailMultilineBuffer(
+			input.formatFirstline,
+			input.multilineFlushInterval,
+			func(lines string) error {
+				return watcher.parseLine(lines)
+			},
+		)
 	}
 	handler, err := NewTailEventHandler(
 		input.logger,
@@ -971,6 +1275,7 @@ func (input *TailInput) openTailWatcher(path string) (*TailWatcher, error) {
 		func(line string) error {
 			return watcher.parseLine(line)
 		},
+		multiline,
 		func() error {
 			return watcher.tailFileInfo.Dispose()
 		},
@@ -978,33 +1283,29 @@ func (input *TailInput) openTailWatcher(path string) (*TailWatcher, error) {
 	if err != nil {
 		return nil, err
 	}
-	lineParser, err := input.lineParserFactory.New(func(record ik.FluentRecord) error {
-		record.Tag = input.tagPrefix
-		input.pump.EmitOne(record)
-		return nil
-	})
+	lineParser, err := input.newLineParser(path, watcher.tag)
 	if err != nil {
 		handler.Dispose()
 		return nil, err
 	}
-	newStatWatcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		handler.Dispose()
-		return nil, err
-	}
-	err = newStatWatcher.Watch(path)
-	if err != nil {
-		newStatWatcher.Close()
-		handler.Dispose()
-		return nil, err
+	watcher.changeChan = make(chan struct{}, 1)
+	if input.statWatcher != nil {
+		err = input.statWatcher.Watch(path, watcher.notifyChange)
+		if err != nil {
+			handler.Dispose()
+			return nil, err
+		}
+		watcher.watching = true
 	}
 
 	watcher.input = input
 	watcher.tailFileInfo = tailFileInfo.Duplicate()
 	watcher.handler = handler
-	watcher.statWatcher = newStatWatcher
 	watcher.lineParser = lineParser
-	watcher.timer = time.NewTicker(time.Duration(1000000000)) // XXX
+	if input.enableWatchTimer || multiline != nil {
+		// multiline events are flushed on ticks
+		watcher.timer = time.NewTicker(time.Duration(1000000000)) // XXX
+	}
 	watcher.controlChan = make(chan bool, 1)
 
 	err = input.engine.Spawn(watcher)
@@ -1017,23 +1318,34 @@ func (input *TailInput) openTailWatcher(path string) (*TailWatcher, error) {
 }
 
 type TailInput struct {
-	factory           *TailInputFactory
-	engine            ik.Engine
-	port              ik.Port
-	logger            ik.Logger
-	pathSet           *PathSet
-	tagPrefix         string
-	tagSuffix         string
-	rotateWait        time.Duration
-	readFromHead      bool
-	refreshInterval   time.Duration
-	readBufferSize    int
-	lineParserFactory ik.LineParserFactory
-	positionFile      *TailPositionFile
-	pump              *ik.RecordPump
-	watchers          map[string]*TailWatcher
-	refreshTimer      *time.Ticker
-	controlChan       chan struct{}
+	factory                *TailInputFactory
+	engine                 ik.Engine
+	port                   ik.Port
+	logger                 ik.Logger
+	pathSet                *PathSet
+	tagPrefix              string
+	tagSuffix              string
+	expandTag              bool
+	pathKey                string
+	rotateWait             time.Duration
+	readFromHead           bool
+	refreshInterval        time.Duration
+	readBufferSize         int
+	lineParserFactory      ik.LineParserFactory
+	formatFirstline        *regexp.Regexp
+	multilineFlushInterval time.Duration
+	enableWatchTimer       bool
+	statWatcher            tailStatWatcher
+	positionFile           *TailPositionFile
+	pump                   *ik.RecordPump
+	watchers               map[string]*TailWatcher
+	refreshTimer           *time.Ticker
+	refreshChan            chan struct{}
+	ingestGzip             bool
+	ingesters              map[string]*TailGzipIngester
+	ingestersMtx           sync.Mutex
+	skippedPaths           map[string]struct{}
+	controlChan            chan struct{}
 }
 
 func (input *TailInput) Factory() ik.Plugin {
@@ -1053,23 +1365,34 @@ func (input *TailInput) Run() error {
 				return err
 			}
 			return ik.Continue
+		case <-input.refreshChan:
+			err := input.refreshWatchers()
+			if err != nil {
+				return err
+			}
+			return ik.Continue
 		case <-input.controlChan:
-			return nil
+			return input.cleanup()
 		}
 	}
-	return input.cleanup()
 }
 
 func (input *TailInput) cleanup() error {
 	input.refreshTimer.Stop()
+	if input.statWatcher != nil {
+		input.statWatcher.Close()
+	}
 	errors := []error{
 		input.pump.Shutdown(),
 		input.positionFile.Dispose(),
 	}
 	err := (error)(nil)
 	for _, err_ := range errors {
+		if err_ == nil {
+			continue
+		}
 		input.logger.Error("%s", err_.Error())
-		if err == nil && err_ != nil {
+		if err == nil {
 			err = err_
 		}
 	}
@@ -1108,6 +1431,11 @@ func (input *TailInput) refreshWatchers() error {
 		}
 	}
 	for path, _ := range newPaths {
+		if isGzipPath(path) {
+			// compressed files are never tailed
+			input.refreshGzipPath(path)
+			continue
+		}
 		_, ok := input.watchers[path]
 		if !ok {
 			watcher, err := input.openTailWatcher(path)
@@ -1119,9 +1447,10 @@ func (input *TailInput) refreshWatchers() error {
 	}
 	input.logger.Info("Refreshing watchers...")
 	for _, watcher := range deleted {
-		delete(input.watchers, watcher.tailFileInfo.Path())
+		path := watcher.tailFileInfo.Path()
+		delete(input.watchers, path)
 		watcher.Shutdown()
-		input.logger.Info("Deleted watcher for %s", watcher.tailFileInfo.Path())
+		input.logger.Info("Deleted watcher for %s", path)
 	}
 	failed = false
 	for _, watcher := range added {
@@ -1131,6 +1460,29 @@ func (input *TailInput) refreshWatchers() error {
 	return nil
 }
 
+// watchPatternDirs arranges for the watchers to be refreshed as soon as
+// a file is created in the directories the path patterns point to.
+func (input *TailInput) watchPatternDirs() {
+	for _, pattern := range input.pathSet.patterns {
+		dir := filepath.Dir(pattern)
+		if strings.ContainsAny(dir, "*?[{") {
+			// left to refresh_interval
+			continue
+		}
+		err := input.statWatcher.WatchDir(dir, input.notifyRefresh)
+		if err != nil {
+			input.logger.Warning("%s", err.Error())
+		}
+	}
+}
+
+func (input *TailInput) notifyRefresh() {
+	select {
+	case input.refreshChan <- struct{}{}:
+	default:
+	}
+}
+
 func newTailInput(
 	factory *TailInputFactory,
 	logger ik.Logger,
@@ -1140,14 +1492,21 @@ func newTailInput(
 	lineParserFactory ik.LineParserFactory,
 	tagPrefix string,
 	tagSuffix string,
+	expandTag bool,
+	pathKey string,
 	rotateWait time.Duration,
 	positionFilePath string,
 	readFromHead bool,
 	refreshInterval time.Duration,
 	readBufferSize int,
+	formatFirstline *regexp.Regexp,
+	multilineFlushInterval time.Duration,
+	enableStatWatcher bool,
+	enableWatchTimer bool,
+	ingestGzip bool,
 ) (*TailInput, error) {
 	failed := true
-	positionFile, err := openPositionFile(logger, positionFilePath)
+	positionFile, err := OpenTailPositionFile(logger, positionFilePath)
 	if err != nil {
 		return nil, err
 	}
@@ -1156,6 +1515,14 @@ func newTailInput(
 			positionFile.Dispose()
 		}
 	}()
+	// entries for the files deleted in the meantime would otherwise pile up
+	removed, err := positionFile.Compact()
+	if err != nil {
+		return nil, err
+	}
+	if removed > 0 {
+		logger.Info("Removed %d stale entries from %s", removed, positionFilePath)
+	}
 	pump := ik.NewRecordPump(port, DefaultBacklogSize)
 	defer func() {
 		if failed {
@@ -1163,22 +1530,46 @@ func newTailInput(
 		}
 	}()
 	input := &TailInput{
-		factory:           factory,
-		engine:            engine,
-		logger:            logger,
-		port:              port,
-		pathSet:           pathSet,
-		tagPrefix:         tagPrefix,
-		tagSuffix:         tagSuffix,
-		rotateWait:        rotateWait,
-		readFromHead:      readFromHead,
-		refreshInterval:   refreshInterval,
-		readBufferSize:    readBufferSize,
-		lineParserFactory: lineParserFactory,
-		pump:              pump,
-		positionFile:      positionFile,
-		watchers:          make(map[string]*TailWatcher),
-		controlChan:       make(chan struct{}, 1),
+		factory:                factory,
+		engine:                 engine,
+		logger:                 logger,
+		port:                   port,
+		pathSet:                pathSet,
+		tagPrefix:              tagPrefix,
+		tagSuffix:              tagSuffix,
+		expandTag:              expandTag,
+		pathKey:                pathKey,
+		rotateWait:             rotateWait,
+		readFromHead:           readFromHead,
+		refreshInterval:        refreshInterval,
+		readBufferSize:         readBufferSize,
+		lineParserFactory:      lineParserFactory,
+		formatFirstline:        formatFirstline,
+		multilineFlushInterval: multilineFlushInterval,
+		enableWatchTimer:       enableWatchTimer,
+		pump:                   pump,
+		positionFile:           positionFile,
+		watchers:               make(map[string]*TailWatcher),
+		refreshChan:            make(chan struct{}, 1),
+		ingestGzip:             ingestGzip,
+		ingesters:              make(map[string]*TailGzipIngester),
+		skippedPaths:           make(map[string]struct{}),
+		controlChan:            make(chan struct{}, 1),
+	}
+	if enableStatWatcher {
+		statWatcher, err := newTailStatWatcher(logger)
+		if err != nil {
+			logger.Warning("falling back to the watch timer: %s", err.Error())
+			input.enableWatchTimer = true
+		} else {
+			input.statWatcher = statWatcher
+			defer func() {
+				if failed {
+					statWatcher.Close()
+				}
+			}()
+			input.watchPatternDirs()
+		}
 	}
 	err = engine.Spawn(pump)
 	if err != nil {
@@ -1200,8 +1591,23 @@ func (factory *TailInputFactory) Name() string {
 }
 
 type PathSet struct {
-	patterns []string
-	fs       http.FileSystem
+	patterns              []string
+	excludePatterns       []string
+	limitRecentlyModified time.Duration // zero means no limit
+	fs                    http.FileSystem
+}
+
+func (pathSet *PathSet) isRecentlyModified(path string, now time.Time) bool {
+	f, err := pathSet.fs.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return now.Sub(info.ModTime()) <= pathSet.limitRecentlyModified
 }
 
 func (pathSet *PathSet) Expand() (map[string]struct{}, error) {
@@ -1215,13 +1621,32 @@ func (pathSet *PathSet) Expand() (map[string]struct{}, error) {
 			s[path] = struct{}{}
 		}
 	}
+	for _, pattern := range pathSet.excludePatterns {
+		paths, err := ik.Glob(pathSet.fs, pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			delete(s, path)
+		}
+	}
+	if pathSet.limitRecentlyModified > 0 {
+		now := time.Now()
+		for path, _ := range s {
+			if !pathSet.isRecentlyModified(path, now) {
+				delete(s, path)
+			}
+		}
+	}
 	return s, nil
 }
 
-func newPathSet(fs http.FileSystem, patterns []string) *PathSet {
+func newPathSet(fs http.FileSystem, patterns []string, excludePatterns []string, limitRecentlyModified time.Duration) *PathSet {
 	return &PathSet{
-		patterns: patterns,
-		fs:       fs,
+		patterns:              patterns,
+		excludePatterns:       excludePatterns,
+		limitRecentlyModified: limitRecentlyModified,
+		fs:                    fs,
 	}
 }
 
@@ -1237,17 +1662,37 @@ func splitAndStrip(s string) []string {
 func (factory *TailInputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Input, error) {
 	tagPrefix := ""
 	tagSuffix := ""
+	expandTag := false
+	limitRecentlyModified := time.Duration(0)
 	rotateWait, _ := time.ParseDuration("5s")
 	positionFilePath := ""
 	readFromHead := false
 	refreshInterval, _ := time.ParseDuration("1m")
 	readBufferSize := 4096
+	enableStatWatcher := true
+	enableWatchTimer := true
+	ingestGzip := false
+	formatFirstline := (*regexp.Regexp)(nil)
+	multilineFlushInterval, _ := time.ParseDuration("5s")
 
 	pathStr, ok := config.Attrs["path"]
 	if !ok {
 		return nil, errors.New("required attribute `path' is not specified")
 	}
-	pathSet := newPathSet(engine.Opener().FileSystem(), splitAndStrip(pathStr))
+	excludePatterns := []string{}
+	excludePathStr, ok := config.Attrs["exclude_path"]
+	if ok {
+		excludePatterns = splitAndStrip(excludePathStr)
+	}
+	limitRecentlyModifiedStr, ok := config.Attrs["limit_recently_modified"]
+	if ok {
+		var err error
+		limitRecentlyModified, err = time.ParseDuration(limitRecentlyModifiedStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	pathSet := newPathSet(engine.Opener().FileSystem(), splitAndStrip(pathStr), excludePatterns, limitRecentlyModified)
 	tag, ok := config.Attrs["tag"]
 	if !ok {
 		return nil, errors.New("required attribute `tag' is not specified")
@@ -1256,9 +1701,11 @@ func (factory *TailInputFactory) New(engine ik.Engine, config *ik.ConfigElement)
 	if i >= 0 {
 		tagPrefix = tag[:i]
 		tagSuffix = tag[i+1:]
+		expandTag = true
 	} else {
 		tagPrefix = tag
 	}
+	pathKey := config.Attrs["path_key"]
 	rotateWaitStr, ok := config.Attrs["rotate_wait"]
 	if ok {
 		var err error
@@ -1296,11 +1743,58 @@ func (factory *TailInputFactory) New(engine ik.Engine, config *ik.ConfigElement)
 		}
 	}
 
+	enableStatWatcherStr, ok := config.Attrs["enable_stat_watcher"]
+	if ok {
+		var err error
+		enableStatWatcher, err = strconv.ParseBool(enableStatWatcherStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	enableWatchTimerStr, ok := config.Attrs["enable_watch_timer"]
+	if ok {
+		var err error
+		enableWatchTimer, err = strconv.ParseBool(enableWatchTimerStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ingestGzipStr, ok := config.Attrs["ingest_gzip"]
+	if ok {
+		var err error
+		ingestGzip, err = strconv.ParseBool(ingestGzipStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !enableStatWatcher && !enableWatchTimer {
+		return nil, errors.New("either enable_stat_watcher or enable_watch_timer must be true")
+	}
+
 	format, ok := config.Attrs["format"]
 	if !ok {
 		return nil, errors.New("requires attribute `format' is not specified")
 	}
 
+	formatFirstlineStr, ok := config.Attrs["format_firstline"]
+	if ok {
+		var err error
+		formatFirstline, err = regexp.Compile(ik.StripRegexpDelimiters(formatFirstlineStr))
+		if err != nil {
+			return nil, err
+		}
+	} else if format == "multiline" {
+		return nil, errors.New("format multiline requires attribute `format_firstline'")
+	}
+	multilineFlushIntervalStr, ok := config.Attrs["multiline_flush_interval"]
+	if ok {
+		var err error
+		multilineFlushInterval, err = time.ParseDuration(multilineFlushIntervalStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	lineParserFactoryFactory := engine.LineParserPluginRegistry().LookupLineParserFactoryFactory(format)
 	if lineParserFactoryFactory == nil {
 		return nil, errors.New(fmt.Sprintf("Format `%s' is not supported", format))
@@ -1319,11 +1813,18 @@ func (factory *TailInputFactory) New(engine ik.Engine, config *ik.ConfigElement)
 		lineParserFactory,
 		tagPrefix,
 		tagSuffix,
+		expandTag,
+		pathKey,
 		rotateWait,
 		positionFilePath,
 		readFromHead,
 		refreshInterval,
 		readBufferSize,
+		formatFirstline,
+		multilineFlushInterval,
+		enableStatWatcher,
+		enableWatchTimer,
+		ingestGzip,
 	)
 }
 
@@ -0,0 +1,242 @@
+package plugins
+
+import (
+	"compress/gzip"
+	fileid "github.com/moriyoshi/go-fileid"
+	"github.com/moriyoshi/ik"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// number of the lines read in a single Run() so that Shutdown() is
+// noticed in time
+const gzipLinesPerRun = 1024
+
+// TailGzipIngester reads a gzip-compressed file once from start to finish.
+// While the file is being read, its entry in the position file holds the
+// offset in the decompressed stream so that reading resumes after restart.
+// TailPositionCompleted is recorded once it is done.
+type TailGzipIngester struct {
+	input        *TailInput
+	path         string
+	tag          string
+	id           fileid.FileId
+	tailFileInfo TailFileInfo
+	lineParser   ik.LineParser
+	multiline    *TailMultilineBuffer
+	f            *os.File
+	gz           *gzip.Reader
+	bf           *MyBufferedReader
+	controlChan  chan struct{}
+}
+
+func isGzipPath(path string) bool {
+	return strings.HasSuffix(path, ".gz")
+}
+
+func (ingester *TailGzipIngester) cleanup() {
+	input := ingester.input
+	input.ingestersMtx.Lock()
+	delete(input.ingesters, ingester.path)
+	input.ingestersMtx.Unlock()
+	ingester.gz.Close()
+	ingester.f.Close()
+	ingester.tailFileInfo.Dispose()
+}
+
+func (ingester *TailGzipIngester) parseLine(line string) error {
+	return ingester.input.feedLine(ingester.lineParser, ingester.tag, line)
+}
+
+func (ingester *TailGzipIngester) saveState(position int64) error {
+	if ingester.multiline != nil && ingester.multiline.Pending() {
+		position = ingester.multiline.Position()
+	}
+	ingester.tailFileInfo.SetFileId(ingester.id)
+	ingester.tailFileInfo.SetPosition(position)
+	return ingester.tailFileInfo.Save()
+}
+
+func (ingester *TailGzipIngester) complete() error {
+	if ingester.multiline != nil {
+		err := ingester.multiline.Flush()
+		if err != nil {
+			return err
+		}
+	}
+	err := ingester.saveState(TailPositionCompleted)
+	if err != nil {
+		return err
+	}
+	ingester.input.logger.Info("Finished reading %s", ingester.path)
+	return nil
+}
+
+func (ingester *TailGzipIngester) Run() error {
+	select {
+	case <-ingester.controlChan:
+		ingester.cleanup()
+		return nil
+	default:
+	}
+	logger := ingester.input.logger
+	for i := 0; i < gzipLinesPerRun; i += 1 {
+		position := ingester.bf.position
+		line, ispfx, tryAgain, err := ingester.bf.ReadLine()
+		if err == io.EOF {
+			err = ingester.complete()
+			if err != nil {
+				logger.Error("%s", err.Error())
+			}
+			ingester.cleanup()
+			return err
+		} else if err != nil {
+			logger.Error("%s: %s", ingester.path, err.Error())
+			ingester.cleanup()
+			return err
+		}
+		if tryAgain {
+			// the file was not terminated by line endings
+			line = ingester.bf.ReadRest()
+		}
+		if ispfx {
+			logger.Warning("line too long: %s, position=%d", ingester.path, ingester.bf.position)
+		}
+		if ingester.multiline != nil {
+			err = ingester.multiline.Feed(string(line), position, time.Now())
+		} else {
+			err = ingester.parseLine(string(line))
+		}
+		if err != nil {
+			logger.Error("%s", err.Error())
+			ingester.cleanup()
+			return err
+		}
+	}
+	err := ingester.saveState(ingester.bf.position)
+	if err != nil {
+		logger.Error("%s", err.Error())
+		ingester.cleanup()
+		return err
+	}
+	return ik.Continue
+}
+
+func (ingester *TailGzipIngester) Shutdown() error {
+	select {
+	case ingester.controlChan <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (input *TailInput) openGzipIngester(path string) (*TailGzipIngester, error) {
+	id, err := fileid.GetFileId(path, true)
+	if err != nil {
+		return nil, err
+	}
+	tailFileInfo := input.positionFile.Get(path)
+	if input.positionFile.IsCompleted(path, id) {
+		// record it under this path as well in case the file was renamed,
+		// so that the entry survives the compaction
+		if tailFileInfo.GetPosition() != TailPositionCompleted {
+			tailFileInfo.SetFileId(id)
+			tailFileInfo.SetPosition(TailPositionCompleted)
+			err = tailFileInfo.Save()
+		}
+		tailFileInfo.Dispose()
+		return nil, err
+	}
+	failed := true
+	defer func() {
+		if failed {
+			tailFileInfo.Dispose()
+		}
+	}()
+	position := int64(0)
+	if !tailFileInfo.IsNew() && fileid.IsSame(tailFileInfo.GetFileId(), id) {
+		position = tailFileInfo.GetPosition()
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if failed {
+			f.Close()
+		}
+	}()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	if position > 0 {
+		// resume where it was left off
+		_, err = io.CopyN(ioutil.Discard, gz, position)
+		if err != nil {
+			gz.Close()
+			return nil, err
+		}
+	}
+	ingester := &TailGzipIngester{
+		input:        input,
+		path:         path,
+		tag:          input.tagForPath(path),
+		id:           id,
+		tailFileInfo: tailFileInfo,
+		f:            f,
+		gz:           gz,
+		bf:           NewMyBufferedReader(gz, input.readBufferSize, position),
+		controlChan:  make(chan struct{}, 1),
+	}
+	ingester.lineParser, err = input.newLineParser(path, ingester.tag)
+	if err != nil {
+		gz.Close()
+		return nil, err
+	}
+	if input.formatFirstline != nil {
+		// the flush interval is meaningless as the whole file is at hand
+		ingester.multiline = NewTailMultilineBuffer(input.formatFirstline, 0, ingester.parseLine)
+	}
+	failed = false
+	return ingester, nil
+}
+
+// refreshGzipPath starts reading the compressed file unless it has already
+// been read.
+func (input *TailInput) refreshGzipPath(path string) {
+	if !input.ingestGzip {
+		_, ok := input.skippedPaths[path]
+		if !ok {
+			input.logger.Warning("Skipping the compressed file %s; ingest_gzip is not enabled", path)
+			input.skippedPaths[path] = struct{}{}
+		}
+		return
+	}
+	input.ingestersMtx.Lock()
+	_, ok := input.ingesters[path]
+	input.ingestersMtx.Unlock()
+	if ok {
+		return
+	}
+	ingester, err := input.openGzipIngester(path)
+	if err != nil {
+		input.logger.Error("Failed to read %s: %s", path, err.Error())
+		return
+	}
+	if ingester == nil {
+		return
+	}
+	input.ingestersMtx.Lock()
+	input.ingesters[path] = ingester
+	input.ingestersMtx.Unlock()
+	input.logger.Info("Reading %s", path)
+	err = input.engine.Spawn(ingester)
+	if err != nil {
+		input.logger.Error("%s", err.Error())
+		ingester.cleanup()
+	}
+}
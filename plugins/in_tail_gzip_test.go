@@ -0,0 +1,129 @@
+package plugins
+
+import (
+	"compress/gzip"
+	fileid "github.com/moriyoshi/go-fileid"
+	"github.com/moriyoshi/ik"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+type testLineCollector struct {
+	lines []string
+}
+
+func (collector *testLineCollector) New(receiver func(ik.FluentRecord) error) (ik.LineParser, error) {
+	return collector, nil
+}
+
+func (collector *testLineCollector) Feed(line string) error {
+	collector.lines = append(collector.lines, line)
+	return nil
+}
+
+func writeTestGzipFile(t *testing.T, path string, content string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.FailNow()
+	}
+	defer f.Close()
+	w := gzip.NewWriter(f)
+	w.Write([]byte(content))
+	err = w.Close()
+	if err != nil {
+		t.FailNow()
+	}
+}
+
+func Test_TailGzipIngester(t *testing.T) {
+	dir, err := ioutil.TempDir("", "in_tail")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	positionFile, err := OpenTailPositionFile(&testLogger{t}, path.Join(dir, "pos"))
+	if err != nil {
+		t.FailNow()
+	}
+	defer positionFile.Dispose()
+	collector := &testLineCollector{}
+	input := &TailInput{
+		logger:            &testLogger{t},
+		tagPrefix:         "test",
+		readBufferSize:    4096,
+		lineParserFactory: collector,
+		positionFile:      positionFile,
+		ingesters:         make(map[string]*TailGzipIngester),
+	}
+
+	gzPath := path.Join(dir, "a.log.1.gz")
+	writeTestGzipFile(t, gzPath, "a\nb\nc")
+	ingester, err := input.openGzipIngester(gzPath)
+	if err != nil || ingester == nil {
+		t.FailNow()
+	}
+	for ingester.Run() == ik.Continue {
+	}
+	if len(collector.lines) != 3 || collector.lines[0] != "a" || collector.lines[2] != "c" {
+		t.Logf("%#v", collector.lines)
+		t.Fail()
+	}
+	id, err := fileid.GetFileId(gzPath, true)
+	if err != nil {
+		t.FailNow()
+	}
+	if !positionFile.IsCompleted(gzPath, id) {
+		t.Fail()
+	}
+	// the file is never read again, even if it is renamed
+	err = os.Rename(gzPath, path.Join(dir, "a.log.2.gz"))
+	if err != nil {
+		t.FailNow()
+	}
+	ingester, err = input.openGzipIngester(path.Join(dir, "a.log.2.gz"))
+	if err != nil || ingester != nil {
+		t.Fail()
+	}
+
+	// the id of the file whose path still exists belongs to another file
+	// as the inode was reused
+	gzPath = path.Join(dir, "c.log.1.gz")
+	writeTestGzipFile(t, gzPath, "a\n")
+	id, err = fileid.GetFileId(gzPath, true)
+	if err != nil {
+		t.FailNow()
+	}
+	tailFileInfo := positionFile.Get(path.Join(dir, "a.log.2.gz"))
+	tailFileInfo.SetFileId(id)
+	tailFileInfo.SetPosition(TailPositionCompleted)
+	tailFileInfo.Dispose()
+	if positionFile.IsCompleted(gzPath, id) {
+		t.Fail()
+	}
+
+	// resumes from the position in the decompressed stream
+	gzPath = path.Join(dir, "b.log.1.gz")
+	writeTestGzipFile(t, gzPath, "a\nb\nc\n")
+	id, err = fileid.GetFileId(gzPath, true)
+	if err != nil {
+		t.FailNow()
+	}
+	tailFileInfo = positionFile.Get(gzPath)
+	tailFileInfo.SetFileId(id)
+	tailFileInfo.SetPosition(2)
+	tailFileInfo.Save()
+	tailFileInfo.Dispose()
+	collector.lines = nil
+	ingester, err = input.openGzipIngester(gzPath)
+	if err != nil || ingester == nil {
+		t.FailNow()
+	}
+	for ingester.Run() == ik.Continue {
+	}
+	if len(collector.lines) != 2 || collector.lines[0] != "b" || collector.lines[1] != "c" {
+		t.Logf("%#v", collector.lines)
+		t.Fail()
+	}
+}
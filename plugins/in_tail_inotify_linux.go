@@ -0,0 +1,252 @@
+package plugins
+
+import (
+	"errors"
+	"github.com/moriyoshi/ik"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	inotifyFileMask = syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_MOVE_SELF | syscall.IN_DELETE_SELF
+	inotifyDirMask  = syscall.IN_CREATE | syscall.IN_MOVED_TO
+)
+
+type inotifyFileWatch struct {
+	notify func()
+}
+
+type inotifyDirWatch struct {
+	wd       int32
+	refcount int
+	notify   func() // called when an entry is created in the directory
+}
+
+// inotifyStatWatcher watches the files with a single inotify instance.
+// Besides the file itself, the parent directory is watched so that the
+// file that is newly created by rotation is picked up as well.
+type inotifyStatWatcher struct {
+	logger ik.Logger
+	f      *os.File
+	fd     int
+	files  map[string]*inotifyFileWatch
+	dirs   map[string]*inotifyDirWatch
+	// a path may be associated with several descriptors as the old
+	// file is still watched for a while after rotation
+	fileWds map[int32]string
+	dirWds  map[int32]string
+	// the descriptor may be reused by another file once closed
+	closed bool
+	mtx    sync.Mutex
+}
+
+func (watcher *inotifyStatWatcher) addFileWatch(path string) error {
+	wd, err := syscall.InotifyAddWatch(watcher.fd, path, inotifyFileMask)
+	if err != nil {
+		return &os.PathError{Op: "inotify_add_watch", Path: path, Err: err}
+	}
+	watcher.fileWds[int32(wd)] = path
+	return nil
+}
+
+func (watcher *inotifyStatWatcher) addDirRef(dir string) error {
+	dirWatch, ok := watcher.dirs[dir]
+	if !ok {
+		wd, err := syscall.InotifyAddWatch(watcher.fd, dir, inotifyDirMask)
+		if err != nil {
+			return &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+		}
+		dirWatch = &inotifyDirWatch{wd: int32(wd)}
+		watcher.dirs[dir] = dirWatch
+		watcher.dirWds[int32(wd)] = dir
+	}
+	dirWatch.refcount += 1
+	return nil
+}
+
+func (watcher *inotifyStatWatcher) deleteDirRef(dir string) {
+	if watcher.closed {
+		return
+	}
+	dirWatch, ok := watcher.dirs[dir]
+	if !ok {
+		return
+	}
+	dirWatch.refcount -= 1
+	if dirWatch.refcount == 0 {
+		syscall.InotifyRmWatch(watcher.fd, uint32(dirWatch.wd))
+		delete(watcher.dirWds, dirWatch.wd)
+		delete(watcher.dirs, dir)
+	}
+}
+
+func (watcher *inotifyStatWatcher) Watch(path string, notify func()) error {
+	watcher.mtx.Lock()
+	defer watcher.mtx.Unlock()
+	if watcher.closed {
+		return os.ErrClosed
+	}
+	_, ok := watcher.files[path]
+	if ok {
+		// the directory is referred to once per file
+		return nil
+	}
+	err := watcher.addDirRef(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	err = watcher.addFileWatch(path)
+	if err != nil && !os.IsNotExist(err) {
+		watcher.deleteDirRef(filepath.Dir(path))
+		return err
+	}
+	// a file that does not exist yet is watched once it is created
+	watcher.files[path] = &inotifyFileWatch{notify}
+	return nil
+}
+
+func (watcher *inotifyStatWatcher) Unwatch(path string) error {
+	watcher.mtx.Lock()
+	defer watcher.mtx.Unlock()
+	if watcher.closed {
+		return nil
+	}
+	_, ok := watcher.files[path]
+	if !ok {
+		return nil
+	}
+	delete(watcher.files, path)
+	for wd, path_ := range watcher.fileWds {
+		if path_ == path {
+			syscall.InotifyRmWatch(watcher.fd, uint32(wd))
+			delete(watcher.fileWds, wd)
+		}
+	}
+	watcher.deleteDirRef(filepath.Dir(path))
+	return nil
+}
+
+func (watcher *inotifyStatWatcher) WatchDir(dir string, notify func()) error {
+	watcher.mtx.Lock()
+	defer watcher.mtx.Unlock()
+	if watcher.closed {
+		return os.ErrClosed
+	}
+	err := watcher.addDirRef(dir)
+	if err != nil {
+		return err
+	}
+	watcher.dirs[dir].notify = notify
+	return nil
+}
+
+func (watcher *inotifyStatWatcher) Close() error {
+	watcher.mtx.Lock()
+	defer watcher.mtx.Unlock()
+	if watcher.closed {
+		return nil
+	}
+	watcher.closed = true
+	return watcher.f.Close()
+}
+
+// handleEvent returns the functions to call for the event.
+func (watcher *inotifyStatWatcher) handleEvent(wd int32, mask uint32, name string) []func() {
+	watcher.mtx.Lock()
+	defer watcher.mtx.Unlock()
+	notifies := make([]func(), 0, 2)
+	if watcher.closed {
+		return notifies
+	}
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		// events were lost; everybody has to look into the files
+		for _, fileWatch := range watcher.files {
+			notifies = append(notifies, fileWatch.notify)
+		}
+		for _, dirWatch := range watcher.dirs {
+			if dirWatch.notify != nil {
+				notifies = append(notifies, dirWatch.notify)
+			}
+		}
+		return notifies
+	}
+	if path, ok := watcher.fileWds[wd]; ok {
+		if mask&syscall.IN_IGNORED != 0 {
+			delete(watcher.fileWds, wd)
+			return notifies
+		}
+		fileWatch, ok := watcher.files[path]
+		if ok {
+			notifies = append(notifies, fileWatch.notify)
+		}
+	} else if dir, ok := watcher.dirWds[wd]; ok && mask&inotifyDirMask != 0 {
+		path := filepath.Join(dir, name)
+		fileWatch, ok := watcher.files[path]
+		if ok {
+			err := watcher.addFileWatch(path)
+			if err != nil {
+				watcher.logger.Warning("%s", err.Error())
+			}
+			notifies = append(notifies, fileWatch.notify)
+		}
+		dirWatch := watcher.dirs[dir]
+		if dirWatch.notify != nil {
+			notifies = append(notifies, dirWatch.notify)
+		}
+	}
+	return notifies
+}
+
+func (watcher *inotifyStatWatcher) readEvents() {
+	buf := make([]byte, (syscall.SizeofInotifyEvent+syscall.NAME_MAX+1)*64)
+	for {
+		n, err := watcher.f.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				watcher.logger.Error("inotify: %s", err.Error())
+			}
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			name := ""
+			nameStart := offset + syscall.SizeofInotifyEvent
+			nameEnd := nameStart + int(event.Len)
+			if event.Len > 0 && nameEnd <= n {
+				name = string(buf[nameStart:nameEnd])
+				// the name is padded with NULs
+				for i := 0; i < len(name); i += 1 {
+					if name[i] == 0 {
+						name = name[:i]
+						break
+					}
+				}
+			}
+			for _, notify := range watcher.handleEvent(event.Wd, event.Mask, name) {
+				notify()
+			}
+			offset = nameEnd
+		}
+	}
+}
+
+func newTailStatWatcher(logger ik.Logger) (tailStatWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	watcher := &inotifyStatWatcher{
+		logger:  logger,
+		f:       os.NewFile(uintptr(fd), "inotify"),
+		fd:      fd,
+		files:   make(map[string]*inotifyFileWatch),
+		dirs:    make(map[string]*inotifyDirWatch),
+		fileWds: make(map[int32]string),
+		dirWds:  make(map[int32]string),
+	}
+	go watcher.readEvents()
+	return watcher, nil
+}
@@ -0,0 +1,150 @@
+package plugins
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func waitForNotification(notified chan struct{}) bool {
+	select {
+	case <-notified:
+		return true
+	case <-time.After(5 * time.Second):
+		return false
+	}
+}
+
+func Test_inotifyStatWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "in_tail")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	watcher, err := newTailStatWatcher(&testLogger{t})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	filePath := path.Join(dir, "a.log")
+	fileNotified := make(chan struct{}, 16)
+	dirNotified := make(chan struct{}, 16)
+	// the file is watched before it is created
+	err = watcher.Watch(filePath, func() { fileNotified <- struct{}{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = watcher.WatchDir(dir, func() { dirNotified <- struct{}{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(filePath)
+	if err != nil {
+		t.FailNow()
+	}
+	if !waitForNotification(fileNotified) || !waitForNotification(dirNotified) {
+		t.Log("creation")
+		t.FailNow()
+	}
+	f.WriteString("a\n")
+	f.Close()
+	if !waitForNotification(fileNotified) {
+		t.Log("modification")
+		t.FailNow()
+	}
+
+	// rotation
+	err = os.Rename(filePath, filePath+".1")
+	if err != nil {
+		t.FailNow()
+	}
+	if !waitForNotification(fileNotified) {
+		t.Log("move")
+		t.FailNow()
+	}
+	ioutil.WriteFile(filePath, []byte("b\n"), 0666)
+	if !waitForNotification(fileNotified) {
+		t.Log("creation after rotation")
+		t.FailNow()
+	}
+
+	err = watcher.Unwatch(filePath)
+	if err != nil {
+		t.FailNow()
+	}
+	for len(fileNotified) > 0 {
+		<-fileNotified
+	}
+	ioutil.WriteFile(filePath, []byte("c\n"), 0666)
+	if !waitForNotification(dirNotified) {
+		t.FailNow()
+	}
+	select {
+	case <-fileNotified:
+		t.Log("notified after unwatched")
+		t.Fail()
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func Test_inotifyStatWatcher_watchTwice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "in_tail")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	watcher_, err := newTailStatWatcher(&testLogger{t})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher_.Close()
+	watcher := watcher_.(*inotifyStatWatcher)
+	filePath := path.Join(dir, "a.log")
+	for i := 0; i < 2; i += 1 {
+		err = watcher.Watch(filePath, func() {})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = watcher.Unwatch(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the directory is no longer watched once the file is unwatched
+	if len(watcher.dirs) != 0 || len(watcher.dirWds) != 0 {
+		t.Fail()
+	}
+}
+
+func Test_inotifyStatWatcher_unwatchAfterClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "in_tail")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	watcher_, err := newTailStatWatcher(&testLogger{t})
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher := watcher_.(*inotifyStatWatcher)
+	filePath := path.Join(dir, "a.log")
+	err = watcher.Watch(filePath, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = watcher.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the watches are left alone as the descriptor is no longer ours
+	if watcher.Unwatch(filePath) != nil || len(watcher.files) != 1 || len(watcher.dirs) != 1 {
+		t.Fail()
+	}
+	if watcher.Watch(filePath, func() {}) == nil || watcher.Close() != nil {
+		t.Fail()
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package plugins
+
+import (
+	"errors"
+	"github.com/moriyoshi/ik"
+)
+
+func newTailStatWatcher(logger ik.Logger) (tailStatWatcher, error) {
+	return nil, errors.New("stat watcher is not supported on this platform")
+}
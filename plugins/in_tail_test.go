@@ -2,10 +2,16 @@ package plugins
 
 import (
 	fileid "github.com/moriyoshi/go-fileid"
+	"github.com/moriyoshi/ik"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 )
 
 func Test_MyBufferedReader(t *testing.T) {
@@ -422,3 +428,261 @@ func Test_buildTagFromPath(t *testing.T) {
 		t.Fail()
 	}
 }
+
+type testMessageParser struct {
+	receiver func(ik.FluentRecord) error
+}
+
+func (parser *testMessageParser) New(receiver func(ik.FluentRecord) error) (ik.LineParser, error) {
+	return &testMessageParser{receiver}, nil
+}
+
+func (parser *testMessageParser) Feed(line string) error {
+	return parser.receiver(ik.FluentRecord{Data: map[string]interface{}{"message": line}})
+}
+
+// testChanPort passes the record sets that are not empty to the channel.
+type testChanPort chan ik.FluentRecordSet
+
+func (port testChanPort) Emit(recordSets []ik.FluentRecordSet) error {
+	for _, recordSet := range recordSets {
+		port <- recordSet
+	}
+	return nil
+}
+
+func Test_TailInput_newLineParser(t *testing.T) {
+	port := make(testChanPort, 1)
+	input := &TailInput{
+		logger:            &testLogger{t},
+		tagPrefix:         "app.",
+		tagSuffix:         ".log",
+		expandTag:         true,
+		pathKey:           "path",
+		lineParserFactory: &testMessageParser{},
+		pump:              ik.NewRecordPump(port, 16),
+	}
+	go input.pump.Run()
+	defer input.pump.Shutdown()
+	path := "/var/log/a.log"
+	tag := input.tagForPath(path)
+	if tag != "app.var.log.a.log.log" {
+		t.Log(tag)
+		t.Fail()
+	}
+	lineParser, err := input.newLineParser(path, tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = input.feedLine(lineParser, tag, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case recordSet := <-port:
+		if recordSet.Tag != tag || len(recordSet.Records) != 1 {
+			t.Logf("%#v", recordSet)
+			t.FailNow()
+		}
+		data := recordSet.Records[0].Data
+		if data["path"] != path || data["message"] != "a" {
+			t.Logf("%#v", data)
+			t.Fail()
+		}
+	case <-time.After(5 * time.Second):
+		t.Fail()
+	}
+}
+
+func Test_TailEventHandler_multiline(t *testing.T) {
+	tempFile, err := ioutil.TempFile("", "in_tail")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+	target, err := openTarget(tempFile.Name())
+	if err != nil {
+		t.FailNow()
+	}
+	events := make([]string, 0)
+	savedPosition := int64(-1)
+	multiline := NewTailMultilineBuffer(regexp.MustCompile(`^\S`), 5*time.Second, func(lines string) error {
+		events = append(events, lines)
+		return nil
+	})
+	handler, err := NewTailEventHandler(
+		&testLogger{t},
+		target,
+		0,
+		5*time.Second,
+		4096,
+		nil,
+		func(target TailTarget, position int64) error {
+			savedPosition = position
+			return nil
+		},
+		func(line string) error {
+			t.Fail()
+			return nil
+		},
+		multiline,
+		nil,
+	)
+	if err != nil {
+		t.FailNow()
+	}
+	defer handler.Dispose()
+
+	now := time.Unix(1000, 0)
+	tempFile.WriteString("first\n\tcontinued\nsecond\n\tcontinued\n")
+	err = handler.OnChange(now)
+	if err != nil {
+		t.FailNow()
+	}
+	if len(events) != 1 || events[0] != "first\n\tcontinued" {
+		t.Logf("%#v", events)
+		t.Fail()
+	}
+	// restarting from here must read "second" again
+	if savedPosition != 17 {
+		t.Log(savedPosition)
+		t.Fail()
+	}
+
+	err = handler.OnChange(now.Add(5 * time.Second))
+	if err != nil {
+		t.FailNow()
+	}
+	if len(events) != 2 || events[1] != "second\n\tcontinued" {
+		t.Logf("%#v", events)
+		t.Fail()
+	}
+	if savedPosition != 35 {
+		t.Log(savedPosition)
+		t.Fail()
+	}
+}
+
+func Test_PathSet_Expand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "in_tail")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a.log", "b.log", "a.log.1.gz", "old.log"} {
+		err = ioutil.WriteFile(path.Join(dir, name), []byte{}, 0666)
+		if err != nil {
+			t.FailNow()
+		}
+	}
+	longAgo := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(path.Join(dir, "old.log"), longAgo, longAgo)
+
+	pathSet := newPathSet(http.Dir("/"), []string{dir + "/*"}, []string{dir + "/*.gz"}, 24*time.Hour)
+	paths, err := pathSet.Expand()
+	if err != nil {
+		t.FailNow()
+	}
+	t.Logf("%#v", paths)
+	if len(paths) != 2 {
+		t.Fail()
+	}
+	for _, name := range []string{"a.log", "b.log"} {
+		if _, ok := paths[path.Join(dir, name)]; !ok {
+			t.Fail()
+		}
+	}
+}
+
+func Test_TailPositionFile_Compact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "in_tail")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	positionFilePath := path.Join(dir, "pos")
+	positionFile, err := OpenTailPositionFile(&testLogger{t}, positionFilePath)
+	if err != nil {
+		t.FailNow()
+	}
+	for i, name := range []string{"a", "b", "c"} {
+		// "b" has been deleted
+		if name != "b" {
+			err = ioutil.WriteFile(path.Join(dir, name), []byte{}, 0666)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		tailFileInfo := positionFile.Get(path.Join(dir, name))
+		tailFileInfo.SetPosition(int64(i + 1))
+		tailFileInfo.Save()
+		tailFileInfo.Dispose()
+	}
+	removed, err := positionFile.Compact()
+	if err != nil || removed != 1 {
+		t.Fail()
+	}
+	inUse := positionFile.Get(path.Join(dir, "a"))
+	ok, err := positionFile.Remove(path.Join(dir, "a"))
+	if err != nil || !ok {
+		t.Fail()
+	}
+	// the update of the removed entry must not overwrite the others
+	inUse.SetPosition(100)
+	inUse.Save()
+	inUse.Dispose()
+	positionFile.Dispose()
+
+	positionFile, err = OpenTailPositionFile(&testLogger{t}, positionFilePath)
+	if err != nil {
+		t.FailNow()
+	}
+	defer positionFile.Dispose()
+	paths := positionFile.Paths()
+	if len(paths) != 1 || paths[0] != path.Join(dir, "c") {
+		t.Logf("%#v", paths)
+		t.FailNow()
+	}
+	tailFileInfo := positionFile.Get(path.Join(dir, "c"))
+	defer tailFileInfo.Dispose()
+	if tailFileInfo.IsNew() || tailFileInfo.GetPosition() != 3 {
+		t.Fail()
+	}
+	if _, err := os.Stat(positionFilePath + ".tmp"); !os.IsNotExist(err) {
+		t.Fail()
+	}
+}
+
+func Test_TailPositionFile_readOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "in_tail")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	positionFilePath := path.Join(dir, "pos")
+	err = ioutil.WriteFile(positionFilePath, marshalPositionFileData(&tailPositionFileData{Path: "a", Position: 1}), 0666)
+	if err != nil {
+		t.FailNow()
+	}
+	mtime := time.Unix(1, 0)
+	err = os.Chtimes(positionFilePath, mtime, mtime)
+	if err != nil {
+		t.FailNow()
+	}
+	positionFile, err := OpenTailPositionFile(&testLogger{t}, positionFilePath)
+	if err != nil {
+		t.FailNow()
+	}
+	tailFileInfo := positionFile.Get("a")
+	if tailFileInfo.GetPosition() != 1 {
+		t.Fail()
+	}
+	tailFileInfo.Dispose()
+	positionFile.Dispose()
+	// the file is not rewritten as nothing was changed
+	info, err := os.Stat(positionFilePath)
+	if err != nil || !info.ModTime().Equal(mtime) {
+		t.Fail()
+	}
+}
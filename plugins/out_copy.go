@@ -0,0 +1,145 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type CopyOutput struct {
+	factory *CopyOutputFactory
+	logger  ik.Logger
+	fanout  *ik.Fanout
+}
+
+type CopyOutputFactory struct {
+}
+
+// ignoreErrorStore keeps the error of a store from stopping the rest.
+type ignoreErrorStore struct {
+	output ik.Output
+	logger ik.Logger
+}
+
+func (store *ignoreErrorStore) Emit(recordSets []ik.FluentRecordSet) error {
+	err := store.output.Emit(recordSets)
+	if err != nil {
+		store.logger.Warning("error ignored in <store>: %s", err.Error())
+	}
+	return nil
+}
+
+func (output *CopyOutput) Emit(recordSets []ik.FluentRecordSet) error {
+	return output.fanout.Emit(recordSets)
+}
+
+func (output *CopyOutput) Factory() ik.Plugin {
+	return output.factory
+}
+
+func (output *CopyOutput) Run() error {
+	time.Sleep(1000000000)
+	return ik.Continue
+}
+
+func (output *CopyOutput) Shutdown() error {
+	return nil
+}
+
+func newCopyOutput(factory *CopyOutputFactory, logger ik.Logger, stores []ik.Output, ignoreErrors []bool, copyMode ik.CopyMode) (*CopyOutput, error) {
+	fanout := &ik.Fanout{CopyMode: copyMode}
+	for i, store := range stores {
+		if ignoreErrors[i] {
+			fanout.AddPort(&ignoreErrorStore{store, logger})
+		} else {
+			fanout.AddPort(store)
+		}
+	}
+	return &CopyOutput{
+		factory: factory,
+		logger:  logger,
+		fanout:  fanout,
+	}, nil
+}
+
+func (factory *CopyOutputFactory) Name() string {
+	return "copy"
+}
+
+func (factory *CopyOutputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Output, error) {
+	registry := engine.OutputFactoryRegistry()
+	if registry == nil {
+		return nil, errors.New("no output plugins are available for <store>")
+	}
+	copyMode := ik.NoCopy
+	copyModeStr, ok := config.Attrs["copy_mode"]
+	if ok {
+		switch copyModeStr {
+		case "no_copy":
+			copyMode = ik.NoCopy
+		case "shallow":
+			copyMode = ik.ShallowCopy
+		case "deep":
+			copyMode = ik.DeepCopy
+		default:
+			return nil, errors.New("unknown copy_mode: " + copyModeStr)
+		}
+	}
+	deepCopyStr, ok := config.Attrs["deep_copy"]
+	if ok {
+		deepCopy, err := strconv.ParseBool(deepCopyStr)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to parse deep_copy: %s", err.Error()))
+		}
+		if deepCopy {
+			copyMode = ik.DeepCopy
+		}
+	}
+	stores := make([]ik.Output, 0)
+	ignoreErrors := make([]bool, 0)
+	for _, elem := range config.Elems {
+		if elem.Name != "store" {
+			continue
+		}
+		// both <store ignore_error> and "ignore_error true" are accepted
+		ignoreError := strings.TrimSpace(elem.Args) == "ignore_error"
+		ignoreErrorStr, ok := elem.Attrs["ignore_error"]
+		if ok {
+			var err error
+			ignoreError, err = strconv.ParseBool(ignoreErrorStr)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Failed to parse ignore_error: %s", err.Error()))
+			}
+		}
+		type_ := elem.Type()
+		if type_ == "" {
+			return nil, errors.New("'type' is not specified in <store>")
+		}
+		storeFactory := registry.LookupOutputFactory(type_)
+		if storeFactory == nil {
+			return nil, errors.New("Could not find output factory: " + type_)
+		}
+		store, err := storeFactory.New(engine, elem)
+		if err != nil {
+			return nil, err
+		}
+		err = engine.Launch(store)
+		if err != nil {
+			return nil, err
+		}
+		stores = append(stores, store)
+		ignoreErrors = append(ignoreErrors, ignoreError)
+	}
+	if len(stores) == 0 {
+		return nil, errors.New("no <store> is specified")
+	}
+	return newCopyOutput(factory, engine.Logger(), stores, ignoreErrors, copyMode)
+}
+
+func (factory *CopyOutputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+}
+
+var _ = AddPlugin(&CopyOutputFactory{})
@@ -0,0 +1,84 @@
+package plugins
+
+import (
+	"errors"
+	"github.com/moriyoshi/ik"
+	"testing"
+	"time"
+)
+
+type testFailingOutput struct {
+	testOutput
+}
+
+func (output *testFailingOutput) Emit(recordSets []ik.FluentRecordSet) error {
+	return errors.New("failed")
+}
+
+func newTestCopyRecordSets() []ik.FluentRecordSet {
+	return []ik.FluentRecordSet{
+		{
+			Tag: "test.tag",
+			Records: []ik.TinyFluentRecord{
+				{
+					Timestamp: time.Unix(1, 0),
+					Data:      map[string]interface{}{"a": "b", "c": map[string]interface{}{"d": "e"}},
+				},
+			},
+		},
+	}
+}
+
+func TestCopyOutput(t *testing.T) {
+	a := &testOutput{}
+	b := &testOutput{}
+	output, err := newCopyOutput(&CopyOutputFactory{}, &testLogger{t}, []ik.Output{a, b}, []bool{false, false}, ik.NoCopy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = output.Emit(newTestCopyRecordSets())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.recordSets) != 1 || len(b.recordSets) != 1 {
+		t.Fail()
+	}
+}
+
+func TestCopyOutput_ignoreError(t *testing.T) {
+	a := &testFailingOutput{}
+	b := &testOutput{}
+	output, err := newCopyOutput(&CopyOutputFactory{}, &testLogger{t}, []ik.Output{a, b}, []bool{false, false}, ik.NoCopy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.Emit(newTestCopyRecordSets()) == nil || len(b.recordSets) != 0 {
+		t.Fail()
+	}
+	output, err = newCopyOutput(&CopyOutputFactory{}, &testLogger{t}, []ik.Output{a, b}, []bool{true, false}, ik.NoCopy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.Emit(newTestCopyRecordSets()) != nil || len(b.recordSets) != 1 {
+		t.Fail()
+	}
+}
+
+func TestCopyOutput_deepCopy(t *testing.T) {
+	a := &testOutput{}
+	b := &testOutput{}
+	output, err := newCopyOutput(&CopyOutputFactory{}, &testLogger{t}, []ik.Output{a, b}, []bool{false, false}, ik.DeepCopy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = output.Emit(newTestCopyRecordSets())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.recordSets[0].Records[0].Data["a"] = "x"
+	a.recordSets[0].Records[0].Data["c"].(map[string]interface{})["d"] = "x"
+	data := b.recordSets[0].Records[0].Data
+	if data["a"] != "b" || data["c"].(map[string]interface{})["d"] != "e" {
+		t.Fail()
+	}
+}
@@ -8,10 +8,14 @@ import (
 	strftime "github.com/jehiah/go-strftime"
 	"github.com/moriyoshi/ik"
 	jnl "github.com/moriyoshi/ik/journal"
+	"github.com/moriyoshi/ik/task"
+	"github.com/pbnjay/strptime"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"path"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -33,6 +37,10 @@ type FileOutput struct {
 	c                 chan []ik.FluentRecordSet
 	cancel            chan bool
 	disableDraining   bool
+	randSource        rand.Source
+	scheduler         *task.RecurringTaskScheduler
+	retryPolicy       *ik.RetryPolicy
+	secondary         ik.Output
 }
 
 type FileOutputPacker struct {
@@ -60,12 +68,31 @@ func (packer *FileOutputPacker) Pack(record ik.FluentRecord) ([]byte, error) {
 	)), nil
 }
 
-func (output *FileOutput) formatTime(timestamp uint64) string {
-	timestamp_ := time.Unix(int64(timestamp), 0)
+var fractionalSecondsRegexp = regexp.MustCompile("%%|%([1-9])?N|%L")
+
+// formatTimeWithFraction is a variant of strftime.Format that recognizes
+// %N (nanoseconds), %3N / %6N / %9N (truncated to the given digits) and
+// %L (milliseconds) as in fluentd.
+func formatTimeWithFraction(format string, timestamp time.Time) string {
+	format = fractionalSecondsRegexp.ReplaceAllStringFunc(format, func(directive string) string {
+		digits := 9
+		if directive == "%%" {
+			return directive
+		} else if directive == "%L" {
+			digits = 3
+		} else if len(directive) == 3 {
+			digits = int(directive[1] - '0')
+		}
+		return fmt.Sprintf("%09d", timestamp.Nanosecond())[0:digits]
+	})
+	return strftime.Format(format, timestamp)
+}
+
+func (output *FileOutput) formatTime(timestamp time.Time) string {
 	if output.timeFormat == "" {
-		return timestamp_.Format(time.RFC3339)
+		return timestamp.Format(time.RFC3339)
 	} else {
-		return strftime.Format(output.timeFormat, timestamp_)
+		return formatTimeWithFraction(output.timeFormat, timestamp)
 	}
 }
 
@@ -158,6 +185,12 @@ func (output *FileOutput) flush(key string, chunk ik.JournalChunk) error {
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err != nil {
+			// don't leave a partial file behind as it will be retried
+			os.Remove(outPath)
+		}
+	}()
 	defer writer.Close()
 
 	if output.compressionFormat == compressionGzip {
@@ -180,6 +213,110 @@ func (output *FileOutput) flush(key string, chunk ik.JournalChunk) error {
 	return nil
 }
 
+// flushWithRetry flushes the chunk and schedules a retry on failure
+// according to the retry policy.  The chunk gets disposed once it is
+// written out or the retries are exhausted, in which case it goes to the
+// secondary output.  An error is returned only if the chunk is lost.
+func (output *FileOutput) flushWithRetry(key string, chunk ik.JournalChunk, retryState *ik.RetryState) error {
+	err := output.flush(key, chunk)
+	if err == nil {
+		chunk.TakeOwnership()
+		chunk.Dispose()
+		return nil
+	}
+	now := time.Now()
+	wait, ok := retryState.Failed(now)
+	if ok && output.scheduler != nil {
+		output.logger.Warning("Failed to flush %s (retry #%d in %s): %s", key, retryState.Retries(), wait.String(), err.Error())
+		err_ := ik.ScheduleOnce(output.scheduler, now.Add(wait), func() {
+			output.flushWithRetry(key, chunk, retryState)
+		})
+		if err_ == nil {
+			return nil
+		}
+		output.logger.Error("Failed to schedule a retry: %s", err_.Error())
+	}
+	err = output.handOver(key, chunk, err)
+	chunk.TakeOwnership()
+	chunk.Dispose()
+	return err
+}
+
+// parseTime recovers the timestamp formatted by formatTime.
+func (output *FileOutput) parseTime(value string) (time.Time, error) {
+	if output.timeFormat == "" {
+		return time.Parse(time.RFC3339, value)
+	} else {
+		return strptime.Parse(value, output.timeFormat)
+	}
+}
+
+// decodeChunk turns the lines in the chunk back into the records.  The
+// time of the hand-over is used for the timestamps that cannot be parsed.
+func (output *FileOutput) decodeChunk(chunk ik.JournalChunk) ([]ik.FluentRecordSet, error) {
+	reader, err := chunk.GetReader()
+	closer, _ := reader.(io.Closer)
+	if closer != nil {
+		defer closer.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	retval := make([]ik.FluentRecordSet, 0, 1)
+	indices := make(map[string]int)
+	for _, line := range strings.Split(string(b), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			return nil, errors.New("malformed line in the chunk: " + line)
+		}
+		data := make(map[string]interface{})
+		err = json.Unmarshal([]byte(fields[2]), &data)
+		if err != nil {
+			return nil, err
+		}
+		timestamp, err := output.parseTime(fields[0])
+		if err != nil {
+			timestamp = now
+		}
+		i, ok := indices[fields[1]]
+		if !ok {
+			i = len(retval)
+			indices[fields[1]] = i
+			retval = append(retval, ik.FluentRecordSet{Tag: fields[1]})
+		}
+		retval[i].Records = append(retval[i].Records, ik.TinyFluentRecord{Timestamp: timestamp, Data: data})
+	}
+	return retval, nil
+}
+
+// handOver passes the records in the chunk that could not be written out
+// to the secondary output, or discards them if there is none.
+func (output *FileOutput) handOver(key string, chunk ik.JournalChunk, cause error) error {
+	if output.secondary == nil {
+		output.logger.Error("Discarding a chunk of %s as the retries are exhausted: %s", key, cause.Error())
+		return cause
+	}
+	recordSets, err := output.decodeChunk(chunk)
+	if err != nil {
+		output.logger.Error("Discarding a chunk of %s as it cannot be decoded: %s", key, err.Error())
+		return err
+	}
+	output.logger.Warning("Handing a chunk of %s over to the secondary output as the retries are exhausted: %s", key, cause.Error())
+	return output.secondary.Emit(recordSets)
+}
+
+func (output *FileOutput) newRetryState() *ik.RetryState {
+	return ik.NewRetryState(output.retryPolicy, output.randSource)
+}
+
 func (output *FileOutput) attachListeners(journal ik.Journal) {
 	if output.symlinkPath != "" {
 		journal.AddNewChunkListener(func(chunk ik.JournalChunk) error {
@@ -201,13 +338,11 @@ func (output *FileOutput) attachListeners(journal ik.Journal) {
 		})
 	}
 	journal.AddFlushListener(func(chunk ik.JournalChunk) error {
-		defer chunk.Dispose()
-		chunk.TakeOwnership()
-		return output.flush(journal.Key(), chunk)
+		return output.flushWithRetry(journal.Key(), chunk, output.newRetryState())
 	})
 }
 
-func newFileOutput(factory *FileOutputFactory, logger ik.Logger, randSource rand.Source, pathPrefix string, pathSuffix string, timeFormat string, compressionFormat int, symlinkPath string, permission os.FileMode, bufferChunkLimit int64, timeSliceFormat string, disableDraining bool) (*FileOutput, error) {
+func newFileOutput(factory *FileOutputFactory, logger ik.Logger, randSource rand.Source, pathPrefix string, pathSuffix string, timeFormat string, compressionFormat int, symlinkPath string, permission os.FileMode, bufferChunkLimit int64, timeSliceFormat string, disableDraining bool, scheduler *task.RecurringTaskScheduler, retryPolicy *ik.RetryPolicy, secondary ik.Output) (*FileOutput, error) {
 	if timeSliceFormat == "" {
 		timeSliceFormat = "%Y%m%d"
 	}
@@ -233,6 +368,10 @@ func newFileOutput(factory *FileOutputFactory, logger ik.Logger, randSource rand
 		c:                 make(chan []ik.FluentRecordSet, 100 /* FIXME */),
 		cancel:            make(chan bool),
 		disableDraining:   disableDraining,
+		randSource:        randSource,
+		scheduler:         scheduler,
+		retryPolicy:       retryPolicy,
+		secondary:         secondary,
 	}
 	journalGroup, err := journalGroupFactory.GetJournalGroup(pathPrefix, retval)
 	if err != nil {
@@ -242,8 +381,7 @@ func newFileOutput(factory *FileOutputFactory, logger ik.Logger, randSource rand
 	slicer := ik.NewSlicer(
 		journalGroup,
 		func(record ik.FluentRecord) string {
-			timestamp_ := time.Unix(int64(record.Timestamp), 0)
-			return strftime.Format(retval.timeSliceFormat, timestamp_)
+			return strftime.Format(retval.timeSliceFormat, record.Timestamp)
 		},
 		&FileOutputPacker{retval},
 		logger,
@@ -252,9 +390,7 @@ func newFileOutput(factory *FileOutputFactory, logger ik.Logger, randSource rand
 		err := (error)(nil)
 		if last != nil {
 			err = last.Flush(func(chunk ik.JournalChunk) error {
-				defer chunk.Dispose()
-				chunk.TakeOwnership()
-				return retval.flush(last.Key(), chunk)
+				return retval.flushWithRetry(last.Key(), chunk, retval.newRetryState())
 			})
 		}
 		if next != nil {
@@ -344,6 +480,16 @@ func (factory *FileOutputFactory) New(engine ik.Engine, config *ik.ConfigElement
 		}
 	}
 
+	retryPolicy, err := ik.NewRetryPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+
+	secondary, err := ik.NewSecondaryOutput(engine, config)
+	if err != nil {
+		return nil, err
+	}
+
 	return newFileOutput(
 		factory,
 		engine.Logger(),
@@ -357,6 +503,9 @@ func (factory *FileOutputFactory) New(engine ik.Engine, config *ik.ConfigElement
 		bufferChunkLimit,
 		timeSliceFormat,
 		disableDraining,
+		engine.RecurringTaskScheduler(),
+		retryPolicy,
+		secondary,
 	)
 }
 
@@ -0,0 +1,126 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"github.com/moriyoshi/ik/task"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func Test_formatTimeWithFraction(t *testing.T) {
+	timestamp := time.Date(2014, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	var result string
+	result = formatTimeWithFraction("%Y-%m-%dT%H:%M:%S.%N", timestamp)
+	t.Log(result)
+	if result != "2014-01-02T03:04:05.123456789" {
+		t.Fail()
+	}
+	result = formatTimeWithFraction("%H:%M:%S.%3N", timestamp)
+	t.Log(result)
+	if result != "03:04:05.123" {
+		t.Fail()
+	}
+	result = formatTimeWithFraction("%H:%M:%S.%L %6N", timestamp)
+	t.Log(result)
+	if result != "03:04:05.123 123456" {
+		t.Fail()
+	}
+	result = formatTimeWithFraction("%%N", timestamp)
+	t.Log(result)
+	if result != "%N" {
+		t.Fail()
+	}
+}
+
+func newTestFileOutput(t *testing.T, dir string, scheduler *task.RecurringTaskScheduler, retryPolicy *ik.RetryPolicy, secondary ik.Output) *FileOutput {
+	output, err := newFileOutput(&FileOutputFactory{}, &testLogger{t}, rand.NewSource(0), path.Join(dir, "buffer."), ".log", "", compressionNone, "", 0600, 16, "", true, scheduler, retryPolicy, secondary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the chunks cannot be written out as the directory is a regular file
+	blocker := path.Join(dir, "blocker")
+	err = ioutil.WriteFile(blocker, []byte{}, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output.pathPrefix = path.Join(blocker, "out.")
+	return output
+}
+
+// writeTestChunks writes the records so that each of them goes to its
+// own chunk.
+func writeTestChunks(t *testing.T, journal ik.Journal, n int) {
+	for i := 0; i < n; i += 1 {
+		err := journal.Write([]byte("1970-01-01T00:00:01Z\ttest.tag\t{\"a\":\"b\"}\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileOutput_flushWithRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ik-file-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	scheduler := task.NewRecurringTaskScheduler(func() time.Time { return time.Now() }, &task.SimpleTaskRunner{})
+	go func() {
+		for {
+			scheduler.ProcessEvent()
+		}
+	}()
+	output := newTestFileOutput(t, dir, scheduler, &ik.RetryPolicy{RetryWait: time.Hour, RetryLimit: -1}, nil)
+	journal := output.journalGroup.GetJournal("test")
+	writeTestChunks(t, journal, 3)
+	visited := 0
+	err = journal.Flush(func(chunk ik.JournalChunk) error {
+		visited += 1
+		return output.flushWithRetry("test", chunk, output.newRetryState())
+	})
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+	// a failing chunk must not keep the others from being flushed
+	if visited != 3 {
+		t.Logf("%d", visited)
+		t.Fail()
+	}
+}
+
+func TestFileOutput_secondary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ik-file-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secondary := &testOutput{}
+	output := newTestFileOutput(t, dir, nil, &ik.RetryPolicy{RetryLimit: 0}, secondary)
+	journal := output.journalGroup.GetJournal("test")
+	writeTestChunks(t, journal, 2)
+	err = journal.Flush(func(chunk ik.JournalChunk) error {
+		return output.flushWithRetry("test", chunk, output.newRetryState())
+	})
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+	if len(secondary.recordSets) != 2 {
+		t.Logf("%#v", secondary.recordSets)
+		t.FailNow()
+	}
+	recordSet := secondary.recordSets[0]
+	if recordSet.Tag != "test.tag" || len(recordSet.Records) != 1 {
+		t.FailNow()
+	}
+	record := recordSet.Records[0]
+	if !record.Timestamp.Equal(time.Unix(1, 0)) || record.Data["a"] != "b" {
+		t.Logf("%#v", record)
+		t.Fail()
+	}
+}
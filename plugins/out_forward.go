@@ -2,95 +2,450 @@ package plugins
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"github.com/moriyoshi/ik"
+	jnl "github.com/moriyoshi/ik/journal"
+	"github.com/moriyoshi/ik/task"
 	"github.com/ugorji/go/codec"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"math/rand"
 	"net"
-	"reflect"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+type forwardServer struct {
+	name          string
+	host          string
+	bind          string
+	weight        int
+	standby       bool
+	credentials   *forwardCredentials
+	tlsConfig     *tls.Config
+	available     bool
+	lastHeartbeat time.Time
+}
+
 type ForwardOutput struct {
-	factory *ForwardOutputFactory
-	logger  ik.Logger
-	codec   *codec.MsgpackHandle
-	bind    string
-	enc     *codec.Encoder
-	conn    net.Conn
-	buffer  bytes.Buffer
+	factory            *ForwardOutputFactory
+	logger             ik.Logger
+	codec              *codec.MsgpackHandle
+	servers            []*forwardServer
+	compressionFormat  int
+	timeAsInteger      bool
+	journalGroup       *jnl.FileJournalGroup
+	slicer             *ik.Slicer
+	requireAckResponse bool
+	ackResponseTimeout time.Duration
+	heartbeatType      string
+	heartbeatInterval  time.Duration
+	hardTimeout        time.Duration
+	rand               *rand.Rand
+	scheduler          *task.RecurringTaskScheduler
+	retryState         *ik.RetryState
+	retryPending       bool
+	secondary          ik.Output
+	cancel             chan struct{}
+	disposed           bool
+	mtx                sync.Mutex
+	serverMtx          sync.Mutex
+	rrIndex            int
+}
+
+func (output *ForwardOutput) newChunkId() string {
+	b := make([]byte, 16)
+	for i := range b {
+		b[i] = byte(output.rand.Intn(256))
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func (output *ForwardOutput) encodeTime(timestamp time.Time) interface{} {
+	if output.timeAsInteger {
+		return uint64(timestamp.Unix())
+	}
+	return newEventTime(timestamp)
 }
 
-func (output *ForwardOutput) encodeEntry(tag string, record ik.TinyFluentRecord) error {
-	v := []interface{}{tag, record.Timestamp, record.Data}
-	if output.enc == nil {
-		output.enc = codec.NewEncoder(&output.buffer, output.codec)
+type ForwardOutputPacker struct {
+	output *ForwardOutput
+}
+
+// Pack encodes a record as an entry of PackedForward so that a journal
+// chunk can be sent as is.
+func (packer *ForwardOutputPacker) Pack(record ik.FluentRecord) ([]byte, error) {
+	buffer := bytes.Buffer{}
+	enc := codec.NewEncoder(&buffer, packer.output.codec)
+	err := enc.Encode([]interface{}{packer.output.encodeTime(record.Timestamp), record.Data})
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (output *ForwardOutput) encodeChunk(tag string, entries []byte, chunkId string) ([]byte, error) {
+	option := map[string]interface{}{}
+	if output.compressionFormat == compressionGzip {
+		// CompressedPackedForward
+		buffer := bytes.Buffer{}
+		writer := gzip.NewWriter(&buffer)
+		_, err := writer.Write(entries)
+		if err != nil {
+			return nil, err
+		}
+		err = writer.Close()
+		if err != nil {
+			return nil, err
+		}
+		entries = buffer.Bytes()
+		option["compressed"] = "gzip"
+	}
+	if chunkId != "" {
+		option["chunk"] = chunkId
 	}
-	err := output.enc.Encode(v)
+	v := []interface{}{tag, entries}
+	if len(option) > 0 {
+		v = append(v, option)
+	}
+	buffer := bytes.Buffer{}
+	err := codec.NewEncoder(&buffer, output.codec).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (output *ForwardOutput) waitForAcks(conn net.Conn, pendingChunks map[string]bool) error {
+	err := conn.SetReadDeadline(time.Now().Add(output.ackResponseTimeout))
 	if err != nil {
 		return err
 	}
-	return err
+	dec := codec.NewDecoder(conn, output.codec)
+	for len(pendingChunks) > 0 {
+		response := map[string]interface{}{}
+		err := dec.Decode(&response)
+		if err != nil {
+			return err
+		}
+		chunkId, ok := stringify(response["ack"])
+		if !ok {
+			return errors.New("Unexpected response from the server")
+		}
+		if !pendingChunks[chunkId] {
+			output.logger.Warning("Received ack for unknown chunk: %s", chunkId)
+			continue
+		}
+		delete(pendingChunks, chunkId)
+	}
+	return nil
+}
+
+// selectServers returns the servers to try in order.  The first one is
+// chosen among the available primaries by weighted round-robin, and the
+// rest of them follows as fallbacks.  Standbys are used only when none of
+// the primaries is available.
+func (output *ForwardOutput) selectServers() []*forwardServer {
+	output.serverMtx.Lock()
+	defer output.serverMtx.Unlock()
+	primaries := make([]*forwardServer, 0, len(output.servers))
+	standbys := make([]*forwardServer, 0, len(output.servers))
+	for _, server := range output.servers {
+		if !server.available {
+			continue
+		}
+		if server.standby {
+			standbys = append(standbys, server)
+		} else {
+			primaries = append(primaries, server)
+		}
+	}
+	candidates := primaries
+	if len(candidates) == 0 {
+		candidates = standbys
+	}
+	totalWeight := 0
+	for _, server := range candidates {
+		totalWeight += server.weight
+	}
+	if totalWeight == 0 {
+		return candidates
+	}
+	n := output.rrIndex % totalWeight
+	output.rrIndex += 1
+	for i, server := range candidates {
+		if n < server.weight {
+			retval := make([]*forwardServer, 0, len(candidates))
+			retval = append(retval, candidates[i:]...)
+			return append(retval, candidates[:i]...)
+		}
+		n -= server.weight
+	}
+	panic("never get here")
+}
+
+func (output *ForwardOutput) setAvailability(server *forwardServer, available bool) {
+	output.serverMtx.Lock()
+	defer output.serverMtx.Unlock()
+	if available {
+		server.lastHeartbeat = time.Now()
+		if !server.available {
+			output.logger.Notice("Server %s (%s) is now available", server.name, server.bind)
+		}
+	} else {
+		if output.heartbeatType == "none" {
+			// no chance to recover without heartbeats
+			return
+		}
+		if server.available {
+			output.logger.Warning("Server %s (%s) is now unavailable", server.name, server.bind)
+		}
+	}
+	server.available = available
+}
+
+func (output *ForwardOutput) heartbeat() {
+	for _, server := range output.servers {
+		conn, err := net.DialTimeout("tcp", server.bind, output.heartbeatInterval)
+		if err == nil {
+			conn.Close()
+			output.setAvailability(server, true)
+			continue
+		}
+		output.serverMtx.Lock()
+		timedOut := time.Now().Sub(server.lastHeartbeat) >= output.hardTimeout
+		output.serverMtx.Unlock()
+		if timedOut {
+			output.setAvailability(server, false)
+		}
+	}
+}
+
+func (output *ForwardOutput) sendTo(server *forwardServer, payload []byte, chunkId string) error {
+	var conn net.Conn
+	var err error
+	if server.tlsConfig != nil {
+		conn, err = tls.Dial("tcp", server.bind, server.tlsConfig)
+	} else {
+		conn, err = net.Dial("tcp", server.bind)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if server.credentials != nil {
+		err := server.credentials.clientHandshake(
+			codec.NewEncoder(conn, output.codec),
+			codec.NewDecoder(conn, output.codec),
+		)
+		if err != nil {
+			return errors.New(fmt.Sprintf("handshake failed: %s", err.Error()))
+		}
+	}
+	n, err := conn.Write(payload)
+	if err != nil {
+		return errors.New(fmt.Sprintf("write failed. size: %d, payload size: %d, error: %s", n, len(payload), err.Error()))
+	}
+	if chunkId != "" {
+		err = output.waitForAcks(conn, map[string]bool{chunkId: true})
+		if err != nil {
+			return errors.New(fmt.Sprintf("failed to receive ack for chunk %s: %s", chunkId, err.Error()))
+		}
+	}
+	output.logger.Notice("Forwarded to %s: %d bytes\n", server.name, n)
+	return nil
 }
 
-func (output *ForwardOutput) encodeRecordSet(recordSet ik.FluentRecordSet) error {
-	v := []interface{}{recordSet.Tag, recordSet.Records}
-	if output.enc == nil {
-		output.enc = codec.NewEncoder(&output.buffer, output.codec)
+func (output *ForwardOutput) sendChunk(tag string, chunk ik.JournalChunk) error {
+	reader, err := chunk.GetReader()
+	closer, _ := reader.(io.Closer)
+	if closer != nil {
+		defer closer.Close()
+	}
+	if err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
 	}
-	err := output.enc.Encode(v)
+	if len(entries) == 0 {
+		return nil
+	}
+	chunkId := ""
+	if output.requireAckResponse {
+		chunkId = output.newChunkId()
+	}
+	payload, err := output.encodeChunk(tag, entries, chunkId)
 	if err != nil {
 		return err
 	}
+	servers := output.selectServers()
+	if len(servers) == 0 {
+		return errors.New("no server is available")
+	}
+	for _, server := range servers {
+		err = output.sendTo(server, payload, chunkId)
+		if err == nil {
+			return nil
+		}
+		output.logger.Error("Failed to forward to %s (%s): %s", server.name, server.bind, err.Error())
+		output.setAvailability(server, false)
+	}
 	return err
 }
 
-func (output *ForwardOutput) flush() error {
-	if output.conn == nil {
-		conn, err := net.Dial("tcp", output.bind)
+// flushJournal sends the chunks of the journal from the oldest one.  A
+// chunk is removed only after it has been delivered, so that whatever is
+// left will be sent again on the next flush or after restart.
+func (output *ForwardOutput) flushJournal(journal *jnl.FileJournal, giveUp bool) error {
+	err := journal.Rotate()
+	if err != nil {
+		return err
+	}
+	chunk := journal.GetTailChunk()
+	for chunk != nil {
+		next := chunk.GetNextChunk()
+		if next == nil {
+			// the chunk currently being written
+			chunk.Dispose()
+			break
+		}
+		err := output.sendChunk(journal.Key(), chunk)
+		if err != nil && giveUp {
+			err = output.handOver(journal.Key(), chunk, err)
+		}
 		if err != nil {
-			output.logger.Error("%#v", err.Error())
+			chunk.Dispose()
+			next.Dispose()
 			return err
-		} else {
-			output.conn = conn
 		}
+		chunk.TakeOwnership()
+		chunk.Dispose()
+		chunk = next
+	}
+	return nil
+}
+
+// handOver passes the records in the chunk that could not be delivered
+// to the secondary output, or discards them if there is none.
+func (output *ForwardOutput) handOver(tag string, chunk ik.JournalChunk, cause error) error {
+	if output.secondary == nil {
+		output.logger.Error("Discarding a chunk of %s as the retries are exhausted: %s", tag, cause.Error())
+		return nil
+	}
+	reader, err := chunk.GetReader()
+	closer, _ := reader.(io.Closer)
+	if closer != nil {
+		defer closer.Close()
 	}
-	n, err := output.buffer.WriteTo(output.conn)
 	if err != nil {
-		output.logger.Error("Write failed. size: %d, buf size: %d, error: %#v", n, output.buffer.Len(), err.Error())
-		output.conn = nil
 		return err
 	}
-	if n > 0 {
-		output.logger.Notice("Forwarded: %d bytes (left: %d bytes)\n", n, output.buffer.Len())
+	packed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
 	}
-	output.conn.Close()
-	output.conn = nil
-	return nil
+	entries, err := decodePackedEntries(output.codec, packed, "", 0)
+	if err != nil {
+		return err
+	}
+	recordSet, err := decodeRecordSet(tag, entries)
+	if err != nil {
+		return err
+	}
+	output.logger.Warning("Handing a chunk of %s over to the secondary output as the retries are exhausted: %s", tag, cause.Error())
+	return output.secondary.Emit([]ik.FluentRecordSet{recordSet})
+}
+
+// flushJournals flushes every journal even if some of them fail, and
+// returns the first error.
+func (output *ForwardOutput) flushJournals(giveUp bool) error {
+	err := (error)(nil)
+	for _, key := range output.journalGroup.GetJournalKeys() {
+		err_ := output.flushJournal(output.journalGroup.GetFileJournal(key), giveUp)
+		if err_ != nil {
+			output.logger.Error("Failed to flush %s: %s", key, err_.Error())
+			if err == nil {
+				err = err_
+			}
+		}
+	}
+	return err
+}
+
+func (output *ForwardOutput) flush() error {
+	output.mtx.Lock()
+	defer output.mtx.Unlock()
+	return output.flushJournals(false)
+}
+
+// tryFlush flushes the journals and schedules a retry on failure according
+// to the retry policy.  The chunks that cannot be delivered after the
+// retries are exhausted go to the secondary output.
+func (output *ForwardOutput) tryFlush(retry bool) {
+	output.mtx.Lock()
+	defer output.mtx.Unlock()
+	if output.disposed {
+		return
+	}
+	if output.retryPending && !retry {
+		// wait for the scheduled retry
+		return
+	}
+	output.retryPending = false
+	err := output.flushJournals(false)
+	if err == nil {
+		output.retryState.Reset()
+		return
+	}
+	now := time.Now()
+	wait, ok := output.retryState.Failed(now)
+	if !ok {
+		output.logger.Error("Retries exhausted after %d attempts", output.retryState.Retries())
+		output.retryState.Reset()
+		output.flushJournals(true)
+		return
+	}
+	output.logger.Warning("Flush will be retried in %s (retry #%d)", wait.String(), output.retryState.Retries())
+	err = ik.ScheduleOnce(output.scheduler, now.Add(wait), func() { output.tryFlush(true) })
+	if err != nil {
+		output.logger.Error("Failed to schedule a retry: %s", err.Error())
+		return
+	}
+	output.retryPending = true
 }
 
 func (output *ForwardOutput) run_flush(flush_interval int) {
 	ticker := time.NewTicker(time.Duration(flush_interval) * time.Second)
 	go func() {
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
-				output.flush()
+				output.tryFlush(false)
+			case <-output.cancel:
+				return
 			}
 		}
 	}()
 }
 
-func (output *ForwardOutput) Emit(recordSet []ik.FluentRecordSet) error {
-	for _, recordSet := range recordSet {
-		err := output.encodeRecordSet(recordSet)
-		if err != nil {
-			output.logger.Error("%#v", err)
-			return err
-		}
+func (output *ForwardOutput) Emit(recordSets []ik.FluentRecordSet) error {
+	err := output.slicer.Emit(recordSets)
+	if err != nil {
+		output.logger.Error("%s", err.Error())
 	}
-	return nil
+	return err
 }
 
 func (output *ForwardOutput) Factory() ik.Plugin {
@@ -98,42 +453,206 @@ func (output *ForwardOutput) Factory() ik.Plugin {
 }
 
 func (output *ForwardOutput) Run() error {
-	time.Sleep(1000000000)
+	time.Sleep(output.heartbeatInterval)
+	if output.heartbeatType == "tcp" {
+		output.heartbeat()
+	}
 	return ik.Continue
 }
 
 func (output *ForwardOutput) Shutdown() error {
-	return nil
+	output.mtx.Lock()
+	defer output.mtx.Unlock()
+	if output.disposed {
+		return nil
+	}
+	// the retries scheduled so far are ignored as well
+	output.disposed = true
+	close(output.cancel)
+	return output.journalGroup.Dispose()
 }
 
 type ForwardOutputFactory struct {
+	// number of the outputs that share the same default buffer path
+	defaultBufferPaths map[string]int
+	mtx                sync.Mutex
+}
+
+// parseForwardCredentials builds the credentials used for the handshake
+// from the <security> element; shared_key, username and password can be
+// overridden per server.
+func parseForwardCredentials(config *ik.ConfigElement, serverConfig *ik.ConfigElement) (*forwardCredentials, error) {
+	securityConfig := findConfigElement(config, "security")
+	if securityConfig == nil {
+		return nil, nil
+	}
+	credentials := &forwardCredentials{
+		selfHostname: defaultHostname(),
+	}
+	selfHostname, ok := securityConfig.Attrs["self_hostname"]
+	if ok {
+		credentials.selfHostname = selfHostname
+	}
+	credentials.sharedKey, _ = securityConfig.Attrs["shared_key"]
+	sharedKey, ok := serverConfig.Attrs["shared_key"]
+	if ok {
+		credentials.sharedKey = sharedKey
+	}
+	if credentials.sharedKey == "" {
+		return nil, errors.New("`shared_key' must be specified either in <security> or for the server")
+	}
+	credentials.username, _ = serverConfig.Attrs["username"]
+	credentials.password, _ = serverConfig.Attrs["password"]
+	return credentials, nil
 }
 
-func newForwardOutput(factory *ForwardOutputFactory, logger ik.Logger, bind string) (*ForwardOutput, error) {
-	_codec := codec.MsgpackHandle{}
-	_codec.MapType = reflect.TypeOf(map[string]interface{}(nil))
-	_codec.RawToString = false
-	_codec.StructToArray = true
-	return &ForwardOutput{
-		factory: factory,
-		logger:  logger,
-		codec:   &_codec,
-		bind:    bind,
+// parseForwardServer reads either a <server> element or, for the
+// single-server configuration, the <match> element itself.
+func parseForwardServer(config *ik.ConfigElement, serverConfig *ik.ConfigElement, tlsConfig *tls.Config) (*forwardServer, error) {
+	host, ok := serverConfig.Attrs["host"]
+	if !ok {
+		host = "localhost"
+	}
+	netPort, ok := serverConfig.Attrs["port"]
+	if !ok {
+		netPort = "24224"
+	}
+	bind := host + ":" + netPort
+	name, ok := serverConfig.Attrs["name"]
+	if !ok {
+		name = bind
+	}
+	weightStr, ok := serverConfig.Attrs["weight"]
+	if !ok {
+		weightStr = "60"
+	}
+	weight, err := strconv.Atoi(weightStr)
+	if err != nil || weight < 0 {
+		return nil, errors.New("invalid weight: " + weightStr)
+	}
+	standby := false
+	standbyStr, ok := serverConfig.Attrs["standby"]
+	if ok {
+		standby, err = strconv.ParseBool(standbyStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	credentials, err := parseForwardCredentials(config, serverConfig)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil && tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = host
+	}
+	return &forwardServer{
+		name:          name,
+		host:          host,
+		bind:          bind,
+		weight:        weight,
+		standby:       standby,
+		credentials:   credentials,
+		tlsConfig:     tlsConfig,
+		available:     true,
+		lastHeartbeat: time.Now(),
 	}, nil
 }
 
+func newForwardOutput(factory *ForwardOutputFactory, logger ik.Logger, randSource rand.Source, bufferPath string, bufferChunkLimit int64, servers []*forwardServer, compressionFormat int, timeAsInteger bool, requireAckResponse bool, ackResponseTimeout time.Duration, heartbeatType string, heartbeatInterval time.Duration, hardTimeout time.Duration, scheduler *task.RecurringTaskScheduler, retryPolicy *ik.RetryPolicy, secondary ik.Output) (*ForwardOutput, error) {
+	retval := &ForwardOutput{
+		factory:            factory,
+		logger:             logger,
+		codec:              newForwardCodec(),
+		servers:            servers,
+		compressionFormat:  compressionFormat,
+		timeAsInteger:      timeAsInteger,
+		requireAckResponse: requireAckResponse,
+		ackResponseTimeout: ackResponseTimeout,
+		heartbeatType:      heartbeatType,
+		heartbeatInterval:  heartbeatInterval,
+		hardTimeout:        hardTimeout,
+		rand:               rand.New(randSource),
+		scheduler:          scheduler,
+		retryState:         ik.NewRetryState(retryPolicy, randSource),
+		secondary:          secondary,
+		cancel:             make(chan struct{}),
+	}
+	journalGroupFactory := jnl.NewFileJournalGroupFactory(
+		logger,
+		randSource,
+		func() time.Time { return time.Now() },
+		".log",
+		os.FileMode(0600),
+		bufferChunkLimit,
+	)
+	journalGroup, err := journalGroupFactory.GetJournalGroup(bufferPath, retval)
+	if err != nil {
+		return nil, err
+	}
+	retval.journalGroup = journalGroup
+	retval.slicer = ik.NewSlicer(
+		journalGroup,
+		func(record ik.FluentRecord) string { return record.Tag },
+		&ForwardOutputPacker{retval},
+		logger,
+	)
+	return retval, nil
+}
+
+// defaultForwardBufferPath returns the buffer path under the temporary
+// directory.  It is derived from the match pattern and the servers so
+// that the chunks left by the previous run are picked up again.
+func defaultForwardBufferPath(pattern string, servers []*forwardServer) (string, error) {
+	hash := fnv.New32a()
+	io.WriteString(hash, pattern)
+	hash.Write([]byte{0})
+	for _, server := range servers {
+		io.WriteString(hash, server.bind)
+		hash.Write([]byte{0})
+	}
+	dir := filepath.Join(os.TempDir(), "ik", "forward")
+	err := os.MkdirAll(dir, os.FileMode(0700))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, fmt.Sprintf("%08x", hash.Sum32())), nil
+}
+
+// defaultBufferPath tells apart the outputs that would otherwise share
+// the same buffer path by the order of appearance in the configuration.
+func (factory *ForwardOutputFactory) defaultBufferPath(pattern string, servers []*forwardServer) (string, error) {
+	bufferPath, err := defaultForwardBufferPath(pattern, servers)
+	if err != nil {
+		return "", err
+	}
+	factory.mtx.Lock()
+	defer factory.mtx.Unlock()
+	if factory.defaultBufferPaths == nil {
+		factory.defaultBufferPaths = make(map[string]int)
+	}
+	n := factory.defaultBufferPaths[bufferPath]
+	factory.defaultBufferPaths[bufferPath] = n + 1
+	if n > 0 {
+		bufferPath += fmt.Sprintf("_%d", n)
+	}
+	return bufferPath, nil
+}
+
 func (factory *ForwardOutputFactory) Name() string {
 	return "forward"
 }
 
 func (factory *ForwardOutputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Output, error) {
-	host, ok := config.Attrs["host"]
-	if !ok {
-		host = "localhost"
-	}
-	netPort, ok := config.Attrs["port"]
-	if !ok {
-		netPort = "24224"
+	bufferPath, _ := config.Attrs["buffer_path"]
+	bufferChunkLimit := int64(8 * 1024 * 1024) // 8MB
+	bufferChunkLimitStr, ok := config.Attrs["buffer_chunk_limit"]
+	if ok {
+		var err error
+		bufferChunkLimit, err = ik.ParseCapacityString(bufferChunkLimitStr)
+		if err != nil {
+			return nil, err
+		}
 	}
 	flush_interval_str, ok := config.Attrs["flush_interval"]
 	if !ok {
@@ -143,13 +662,204 @@ func (factory *ForwardOutputFactory) New(engine ik.Engine, config *ik.ConfigElem
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("Failed to parse flush_interval_str: #v", err))
 	}
-	bind := host + ":" + netPort
-	output, err := newForwardOutput(factory, engine.Logger(), bind)
+	compressionFormat := compressionNone
+	compressionFormatStr, ok := config.Attrs["compress"]
+	if ok {
+		if compressionFormatStr == "gz" || compressionFormatStr == "gzip" {
+			compressionFormat = compressionGzip
+		} else if compressionFormatStr != "text" {
+			return nil, errors.New("unknown compression format: " + compressionFormatStr)
+		}
+	}
+	timeAsInteger := false
+	timeAsIntegerStr, ok := config.Attrs["time_as_integer"]
+	if ok {
+		timeAsInteger, err = strconv.ParseBool(timeAsIntegerStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	requireAckResponse := false
+	requireAckResponseStr, ok := config.Attrs["require_ack_response"]
+	if ok {
+		requireAckResponse, err = strconv.ParseBool(requireAckResponseStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ackResponseTimeoutStr, ok := config.Attrs["ack_response_timeout"]
+	if !ok {
+		ackResponseTimeoutStr = "190"
+	}
+	ackResponseTimeout, err := strconv.Atoi(ackResponseTimeoutStr)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to parse ack_response_timeout: %s", err.Error()))
+	}
+	heartbeatType, ok := config.Attrs["heartbeat_type"]
+	if !ok {
+		heartbeatType = "tcp"
+	}
+	if heartbeatType != "tcp" && heartbeatType != "none" {
+		return nil, errors.New("unsupported heartbeat_type: " + heartbeatType)
+	}
+	heartbeatIntervalStr, ok := config.Attrs["heartbeat_interval"]
+	if !ok {
+		heartbeatIntervalStr = "1"
+	}
+	heartbeatInterval, err := strconv.Atoi(heartbeatIntervalStr)
+	if err != nil || heartbeatInterval <= 0 {
+		return nil, errors.New("invalid heartbeat_interval: " + heartbeatIntervalStr)
+	}
+	hardTimeoutStr, ok := config.Attrs["hard_timeout"]
+	if !ok {
+		hardTimeoutStr = "60"
+	}
+	hardTimeout, err := strconv.Atoi(hardTimeoutStr)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to parse hard_timeout: %s", err.Error()))
+	}
+	tlsConfig, err := newClientTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	retryPolicy, err := ik.NewRetryPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := ik.NewSecondaryOutput(engine, config)
+	if err != nil {
+		return nil, err
+	}
+	serverConfigs := make([]*ik.ConfigElement, 0)
+	for _, elem := range config.Elems {
+		if elem.Name == "server" {
+			serverConfigs = append(serverConfigs, elem)
+		}
+	}
+	if len(serverConfigs) == 0 {
+		serverConfigs = append(serverConfigs, config)
+	}
+	servers := make([]*forwardServer, len(serverConfigs))
+	for i, serverConfig := range serverConfigs {
+		servers[i], err = parseForwardServer(config, serverConfig, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if bufferPath == "" {
+		bufferPath, err = factory.defaultBufferPath(config.Args, servers)
+		if err != nil {
+			return nil, err
+		}
+		engine.Logger().Notice("buffer_path is not specified; chunks are buffered in %s", bufferPath)
+	}
+	output, err := newForwardOutput(
+		factory,
+		engine.Logger(),
+		engine.RandSource(),
+		bufferPath,
+		bufferChunkLimit,
+		servers,
+		compressionFormat,
+		timeAsInteger,
+		requireAckResponse,
+		time.Duration(ackResponseTimeout)*time.Second,
+		heartbeatType,
+		time.Duration(heartbeatInterval)*time.Second,
+		time.Duration(hardTimeout)*time.Second,
+		engine.RecurringTaskScheduler(),
+		retryPolicy,
+		secondary,
+	)
+	if err != nil {
+		return nil, err
+	}
 	output.run_flush(flush_interval)
-	return output, err
+	return output, nil
 }
 
 func (factory *ForwardOutputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+	scorekeeper.AddTopic(ik.ScorekeeperTopic{
+		Plugin:      factory,
+		Name:        "servers",
+		DisplayName: "Servers",
+		Description: "Health of each server records are forwarded to",
+		Fetcher:     &ForwardServersTopic{},
+	})
+	scorekeeper.AddTopic(ik.ScorekeeperTopic{
+		Plugin:      factory,
+		Name:        "available_servers",
+		DisplayName: "Available servers",
+		Description: "Number of servers currently considered available",
+		Fetcher:     &AvailableServerCountTopic{},
+	})
+}
+
+type ForwardServersTopic struct{}
+
+type AvailableServerCountTopic struct{}
+
+func (output *ForwardOutput) serverStatus(server *forwardServer) (string, string) {
+	output.serverMtx.Lock()
+	defer output.serverMtx.Unlock()
+	role := "primary"
+	if server.standby {
+		role = "standby"
+	}
+	health := "available"
+	if !server.available {
+		health = "unavailable"
+	}
+	return health, fmt.Sprintf(" (%s, %s, weight=%d, last heartbeat=%s)", server.bind, role, server.weight, server.lastHeartbeat.Format(time.RFC3339))
+}
+
+func (topic *ForwardServersTopic) Markup(output_ ik.PluginInstance) (ik.Markup, error) {
+	output := output_.(*ForwardOutput)
+	chunks := make([]ik.MarkupChunk, 0, len(output.servers)*3)
+	for i, server := range output.servers {
+		health, detail := output.serverStatus(server)
+		text := server.name + ": "
+		if i > 0 {
+			text = ", " + text
+		}
+		chunks = append(chunks,
+			ik.MarkupChunk{Text: text},
+			ik.MarkupChunk{Attrs: ik.Embolden, Text: health},
+			ik.MarkupChunk{Text: detail},
+		)
+	}
+	return ik.Markup{Chunks: chunks}, nil
+}
+
+func (topic *ForwardServersTopic) PlainText(output_ ik.PluginInstance) (string, error) {
+	output := output_.(*ForwardOutput)
+	statuses := make([]string, len(output.servers))
+	for i, server := range output.servers {
+		health, detail := output.serverStatus(server)
+		statuses[i] = server.name + ": " + health + detail
+	}
+	return strings.Join(statuses, ", "), nil
+}
+
+func (topic *AvailableServerCountTopic) Markup(output_ ik.PluginInstance) (ik.Markup, error) {
+	text, err := topic.PlainText(output_)
+	if err != nil {
+		return ik.Markup{}, err
+	}
+	return ik.Markup{Chunks: []ik.MarkupChunk{{Text: text}}}, nil
+}
+
+func (topic *AvailableServerCountTopic) PlainText(output_ ik.PluginInstance) (string, error) {
+	output := output_.(*ForwardOutput)
+	output.serverMtx.Lock()
+	defer output.serverMtx.Unlock()
+	count := 0
+	for _, server := range output.servers {
+		if server.available {
+			count += 1
+		}
+	}
+	return strconv.Itoa(count), nil
 }
 
 var _ = AddPlugin(&ForwardOutputFactory{})
@@ -0,0 +1,280 @@
+package plugins
+
+import (
+	"errors"
+	"github.com/moriyoshi/ik"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func newTestForwardOutput(t *testing.T, bufferPath string, servers []*forwardServer) *ForwardOutput {
+	output, err := newForwardOutput(&ForwardOutputFactory{}, &testLogger{t}, rand.NewSource(0), bufferPath, 1024*1024, servers, compressionNone, false, true, 5*time.Second, "tcp", time.Second, 0, nil, &ik.RetryPolicy{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return output
+}
+
+func newTestForwardServer(t *testing.T, port ik.Port) *ForwardInput {
+	input, err := newForwardInput(&ForwardInputFactory{}, &testLogger{t}, nil, "127.0.0.1:0", port, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for input.Run() == ik.Continue {
+		}
+	}()
+	return input
+}
+
+// unusedBind returns an address nobody listens on.
+func unusedBind(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func emitTestRecord(t *testing.T, output *ForwardOutput) {
+	err := output.Emit([]ik.FluentRecordSet{
+		{
+			Tag:     "test.tag",
+			Records: []ik.TinyFluentRecord{{Timestamp: time.Unix(1, 0), Data: map[string]interface{}{"a": "b"}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForwardOutput_selectServers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ik-forward-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := &forwardServer{name: "a", weight: 2, available: true}
+	b := &forwardServer{name: "b", weight: 1, available: true}
+	c := &forwardServer{name: "c", weight: 1, standby: true, available: true}
+	output := newTestForwardOutput(t, path.Join(dir, "buffer"), []*forwardServer{a, b, c})
+	defer output.Shutdown()
+	counts := map[string]int{}
+	for i := 0; i < 30; i += 1 {
+		servers := output.selectServers()
+		if len(servers) != 2 {
+			t.FailNow()
+		}
+		counts[servers[0].name] += 1
+	}
+	if counts["a"] != 20 || counts["b"] != 10 || counts["c"] != 0 {
+		t.Logf("%#v", counts)
+		t.Fail()
+	}
+	a.available = false
+	b.available = false
+	servers := output.selectServers()
+	if len(servers) != 1 || servers[0] != c {
+		t.Fail()
+	}
+	c.available = false
+	if len(output.selectServers()) != 0 {
+		t.Fail()
+	}
+}
+
+func TestForwardOutput_failover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ik-forward-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	port := &testPort{}
+	input := newTestForwardServer(t, port)
+	defer input.Shutdown()
+
+	down := &forwardServer{name: "down", bind: unusedBind(t), weight: 1, available: true}
+	up := &forwardServer{name: "up", bind: input.listener.Addr().String(), weight: 1, available: true}
+	output := newTestForwardOutput(t, path.Join(dir, "buffer"), []*forwardServer{down, up})
+	defer output.Shutdown()
+	emitTestRecord(t, output)
+	err = output.flush()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(port.recordSets) != 1 || port.recordSets[0].Tag != "test.tag" {
+		t.Fail()
+	}
+	if down.available || !up.available {
+		t.Fail()
+	}
+	output.heartbeat()
+	if down.available || !up.available {
+		t.Fail()
+	}
+}
+
+func TestForwardOutput_resendAfterRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ik-forward-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bufferPath := path.Join(dir, "buffer")
+
+	down := &forwardServer{name: "down", bind: unusedBind(t), weight: 1, available: true}
+	output := newTestForwardOutput(t, bufferPath, []*forwardServer{down})
+	emitTestRecord(t, output)
+	if output.flush() == nil {
+		t.FailNow()
+	}
+	output.Shutdown()
+
+	port := &testPort{}
+	input := newTestForwardServer(t, port)
+	defer input.Shutdown()
+	up := &forwardServer{name: "up", bind: input.listener.Addr().String(), weight: 1, available: true}
+	output = newTestForwardOutput(t, bufferPath, []*forwardServer{up})
+	defer output.Shutdown()
+	err = output.flush()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(port.recordSets) != 1 || len(port.recordSets[0].Records) != 1 {
+		t.Logf("%#v", port.recordSets)
+		t.FailNow()
+	}
+	if port.recordSets[0].Records[0].Data["a"] != "b" {
+		t.Fail()
+	}
+	// delivered chunks must not be sent again
+	err = output.flush()
+	if err != nil || len(port.recordSets) != 1 {
+		t.Fail()
+	}
+}
+
+func TestForwardOutput_secondary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ik-forward-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	down := &forwardServer{name: "down", bind: unusedBind(t), weight: 1, available: true}
+	output := newTestForwardOutput(t, path.Join(dir, "buffer"), []*forwardServer{down})
+	defer output.Shutdown()
+	secondary := &testOutput{}
+	output.secondary = secondary
+	emitTestRecord(t, output)
+	// RetryLimit is zero; the chunk goes to the secondary right away
+	output.tryFlush(false)
+	if len(secondary.recordSets) != 1 || secondary.recordSets[0].Tag != "test.tag" {
+		t.Logf("%#v", secondary.recordSets)
+		t.FailNow()
+	}
+	if secondary.recordSets[0].Records[0].Data["a"] != "b" {
+		t.Fail()
+	}
+	// the chunk is no longer in the journal
+	down.available = true
+	if output.flush() != nil {
+		t.Fail()
+	}
+}
+
+func TestForwardOutput_defaultBufferPath(t *testing.T) {
+	a := []*forwardServer{{bind: "127.0.0.1:24224"}}
+	b := []*forwardServer{{bind: "127.0.0.1:24225"}}
+	factory := &ForwardOutputFactory{}
+	paths := make(map[string]struct{})
+	for _, pattern := range []string{"a.**", "b.**"} {
+		for _, servers := range [][]*forwardServer{a, b, a} {
+			bufferPath, err := factory.defaultBufferPath(pattern, servers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paths[bufferPath] = struct{}{}
+		}
+	}
+	// no two outputs share the path
+	if len(paths) != 6 {
+		t.Logf("%#v", paths)
+		t.Fail()
+	}
+	// while the same one is given on every run
+	pathA, err := defaultForwardBufferPath("a.**", a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pathA_, err := (&ForwardOutputFactory{}).defaultBufferPath("a.**", a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pathA != pathA_ {
+		t.Logf("%s %s", pathA, pathA_)
+		t.Fail()
+	}
+}
+
+// testTagFailingOutput refuses the record sets of the tag.
+type testTagFailingOutput struct {
+	testOutput
+	tag string
+}
+
+func (output *testTagFailingOutput) Emit(recordSets []ik.FluentRecordSet) error {
+	for _, recordSet := range recordSets {
+		if recordSet.Tag == output.tag {
+			return errors.New("refused")
+		}
+	}
+	return output.testOutput.Emit(recordSets)
+}
+
+func TestForwardOutput_flushAllJournals(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ik-forward-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	down := &forwardServer{name: "down", bind: unusedBind(t), weight: 1, available: true}
+	output := newTestForwardOutput(t, path.Join(dir, "buffer"), []*forwardServer{down})
+	secondary := &testTagFailingOutput{tag: "bad"}
+	output.secondary = secondary
+	for _, tag := range []string{"bad", "good"} {
+		err := output.Emit([]ik.FluentRecordSet{
+			{
+				Tag:     tag,
+				Records: []ik.TinyFluentRecord{{Timestamp: time.Unix(1, 0), Data: map[string]interface{}{"a": "b"}}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the failure of a tag does not keep the others from being flushed
+	if output.flushJournals(true) == nil {
+		t.Fail()
+	}
+	if len(secondary.recordSets) != 1 || secondary.recordSets[0].Tag != "good" {
+		t.Logf("%#v", secondary.recordSets)
+		t.Fail()
+	}
+	err = output.Shutdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nothing happens after shutdown
+	output.tryFlush(true)
+	if output.Shutdown() != nil {
+		t.Fail()
+	}
+}
@@ -0,0 +1,61 @@
+package plugins
+
+import (
+	"errors"
+	"github.com/moriyoshi/ik"
+	"time"
+)
+
+// RelabelOutput passes the records to the router of the label designated
+// by "@label".
+type RelabelOutput struct {
+	factory *RelabelOutputFactory
+	logger  ik.Logger
+	port    ik.Port
+}
+
+type RelabelOutputFactory struct {
+}
+
+func (output *RelabelOutput) Emit(recordSets []ik.FluentRecordSet) error {
+	return output.port.Emit(recordSets)
+}
+
+func (output *RelabelOutput) Factory() ik.Plugin {
+	return output.factory
+}
+
+func (output *RelabelOutput) Run() error {
+	time.Sleep(1000000000)
+	return ik.Continue
+}
+
+func (output *RelabelOutput) Shutdown() error {
+	return nil
+}
+
+func newRelabelOutput(factory *RelabelOutputFactory, logger ik.Logger, port ik.Port) (*RelabelOutput, error) {
+	return &RelabelOutput{
+		factory: factory,
+		logger:  logger,
+		port:    port,
+	}, nil
+}
+
+func (factory *RelabelOutputFactory) Name() string {
+	return "relabel"
+}
+
+func (factory *RelabelOutputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Output, error) {
+	// the configurer resolves "@label" and hands it over as the default port
+	_, ok := config.Attrs["@label"]
+	if !ok {
+		return nil, errors.New("'@label' is not specified")
+	}
+	return newRelabelOutput(factory, engine.Logger(), engine.DefaultPort())
+}
+
+func (factory *RelabelOutputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+}
+
+var _ = AddPlugin(&RelabelOutputFactory{})
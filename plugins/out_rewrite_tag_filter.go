@@ -0,0 +1,227 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var captureRegexp = regexp.MustCompile("\\$([0-9]+)")
+
+// maximum number of times the records may be re-emitted under another tag.
+// The records that come back through the other outputs (e.g. another
+// rewrite_tag_filter or copy) are discarded once it is reached, which
+// otherwise go round forever.
+const rewriteTagFilterMaxHops = 256
+
+type rewriteTagRule struct {
+	keys   []string
+	re     *regexp.Regexp
+	invert bool
+	tag    *placeholderTemplate
+}
+
+// RewriteTagFilterOutput re-emits the records under the tag given by the
+// first matching rule.  Records that match no rule are discarded.
+type RewriteTagFilterOutput struct {
+	factory  *RewriteTagFilterOutputFactory
+	logger   ik.Logger
+	port     ik.Port
+	hostname string
+	rules    []*rewriteTagRule
+}
+
+type RewriteTagFilterOutputFactory struct {
+}
+
+// expandTag renders the tag, replacing $1..$9 in the literal parts with the
+// captured groups.
+func (rule *rewriteTagRule) expandTag(context *placeholderContext, captures []string) string {
+	replaceCaptures := func(s string) string {
+		return captureRegexp.ReplaceAllStringFunc(s, func(ref string) string {
+			i, _ := strconv.Atoi(ref[1:])
+			if i < len(captures) {
+				return captures[i]
+			}
+			return ""
+		})
+	}
+	template := rule.tag
+	retval := replaceCaptures(template.literals[0])
+	for i, placeholder := range template.placeholders {
+		retval += placeholderValueToString(placeholder(context)) + replaceCaptures(template.literals[i+1])
+	}
+	return retval
+}
+
+func (output *RewriteTagFilterOutput) rewrite(tag string, tagParts []string, record ik.TinyFluentRecord) (string, bool) {
+	for _, rule := range output.rules {
+		value := lookupRecordValue(record.Data, rule.keys)
+		var captures []string
+		if value != nil {
+			captures = rule.re.FindStringSubmatch(placeholderValueToString(value))
+		}
+		if (captures != nil) == rule.invert {
+			continue
+		}
+		context := &placeholderContext{
+			tag:       tag,
+			tagParts:  tagParts,
+			timestamp: record.Timestamp,
+			hostname:  output.hostname,
+			data:      record.Data,
+		}
+		return rule.expandTag(context, captures), true
+	}
+	return "", false
+}
+
+// loops tells if the records with the tag would come back to this output.
+func (output *RewriteTagFilterOutput) loops(tag string, newTag string) bool {
+	if tag == newTag {
+		return true
+	}
+	router, ok := output.port.(*ik.FluentRouter)
+	return ok && router.Resolve(newTag) == output
+}
+
+func (output *RewriteTagFilterOutput) Emit(recordSets []ik.FluentRecordSet) error {
+	tags := make([]string, 0)
+	recordsPerTag := make(map[string][]ik.TinyFluentRecord)
+	hopsPerTag := make(map[string]int)
+	for _, recordSet := range recordSets {
+		if recordSet.Hops >= rewriteTagFilterMaxHops {
+			output.logger.Warning("rewrite_tag_filter: discarded records as they seem to be looping (%s)", recordSet.Tag)
+			continue
+		}
+		tagParts := strings.Split(recordSet.Tag, ".")
+		for _, record := range recordSet.Records {
+			newTag, ok := output.rewrite(recordSet.Tag, tagParts, record)
+			if !ok || newTag == "" {
+				continue
+			}
+			records, ok := recordsPerTag[newTag]
+			if !ok {
+				if output.loops(recordSet.Tag, newTag) {
+					output.logger.Warning("rewrite_tag_filter: discarded records to avoid the loop (%s => %s)", recordSet.Tag, newTag)
+					continue
+				}
+				tags = append(tags, newTag)
+			}
+			recordsPerTag[newTag] = append(records, record)
+			if hopsPerTag[newTag] < recordSet.Hops+1 {
+				hopsPerTag[newTag] = recordSet.Hops + 1
+			}
+		}
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	newRecordSets := make([]ik.FluentRecordSet, len(tags))
+	for i, tag := range tags {
+		newRecordSets[i] = ik.FluentRecordSet{Tag: tag, Records: recordsPerTag[tag], Hops: hopsPerTag[tag]}
+	}
+	return output.port.Emit(newRecordSets)
+}
+
+func (output *RewriteTagFilterOutput) Factory() ik.Plugin {
+	return output.factory
+}
+
+func (output *RewriteTagFilterOutput) Run() error {
+	time.Sleep(1000000000)
+	return ik.Continue
+}
+
+func (output *RewriteTagFilterOutput) Shutdown() error {
+	return nil
+}
+
+func newRewriteTagFilterOutput(factory *RewriteTagFilterOutputFactory, logger ik.Logger, port ik.Port, hostname string, rules []*rewriteTagRule) (*RewriteTagFilterOutput, error) {
+	return &RewriteTagFilterOutput{
+		factory:  factory,
+		logger:   logger,
+		port:     port,
+		hostname: hostname,
+		rules:    rules,
+	}, nil
+}
+
+func parseRewriteTagRule(config *ik.ConfigElement, matchPatterns []*regexp.Regexp) (*rewriteTagRule, error) {
+	rule, err := parseGrepRule(config)
+	if err != nil {
+		return nil, err
+	}
+	tag, ok := config.Attrs["tag"]
+	if !ok {
+		return nil, errors.New("'tag' is not specified in <rule>")
+	}
+	template, err := compilePlaceholderTemplate(tag)
+	if err != nil {
+		return nil, err
+	}
+	if len(template.placeholders) == 0 && !captureRegexp.MatchString(tag) {
+		for _, re := range matchPatterns {
+			if re.MatchString(tag) {
+				return nil, errors.New(fmt.Sprintf("tag %s would be caught by the <match> itself and loop forever", tag))
+			}
+		}
+	}
+	invert := false
+	invertStr, ok := config.Attrs["invert"]
+	if ok {
+		invert, err = strconv.ParseBool(invertStr)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to parse invert: %s", err.Error()))
+		}
+	}
+	return &rewriteTagRule{
+		keys:   rule.keys,
+		re:     rule.re,
+		invert: invert,
+		tag:    template,
+	}, nil
+}
+
+func (factory *RewriteTagFilterOutputFactory) Name() string {
+	return "rewrite_tag_filter"
+}
+
+func (factory *RewriteTagFilterOutputFactory) New(engine ik.Engine, config *ik.ConfigElement) (ik.Output, error) {
+	patterns := strings.Fields(config.Args)
+	if len(patterns) == 0 {
+		patterns = []string{"**"}
+	}
+	matchPatterns := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		chunk, err := ik.BuildRegexpFromGlobPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		matchPatterns = append(matchPatterns, regexp.MustCompile(chunk))
+	}
+	rules := make([]*rewriteTagRule, 0)
+	for _, elem := range config.Elems {
+		if elem.Name != "rule" {
+			continue
+		}
+		rule, err := parseRewriteTagRule(elem, matchPatterns)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	if len(rules) == 0 {
+		return nil, errors.New("no <rule> is specified")
+	}
+	return newRewriteTagFilterOutput(factory, engine.Logger(), engine.DefaultPort(), defaultHostname(), rules)
+}
+
+func (factory *RewriteTagFilterOutputFactory) BindScorekeeper(scorekeeper *ik.Scorekeeper) {
+}
+
+var _ = AddPlugin(&RewriteTagFilterOutputFactory{})
@@ -0,0 +1,133 @@
+package plugins
+
+import (
+	"github.com/moriyoshi/ik"
+	"testing"
+	"time"
+)
+
+func newTestRewriteTagFilterOutput(t *testing.T, router *ik.FluentRouter, pattern string, rules ...map[string]string) (ik.Output, error) {
+	elems := make([]*ik.ConfigElement, len(rules))
+	for i, rule := range rules {
+		elems[i] = &ik.ConfigElement{Name: "rule", Attrs: rule}
+	}
+	return (&RewriteTagFilterOutputFactory{}).New(
+		&testEngine{logger: &testLogger{t}, port: router},
+		&ik.ConfigElement{Name: "match", Args: pattern, Attrs: map[string]string{}, Elems: elems},
+	)
+}
+
+func TestRewriteTagFilterOutput(t *testing.T) {
+	router := ik.NewFluentRouter()
+	output, err := newTestRewriteTagFilterOutput(t, router, "nginx.**",
+		map[string]string{"key": "$.status", "pattern": "/^([1-5])\\d\\d$/", "tag": "status.$1xx.${tag_parts[1]}"},
+		map[string]string{"key": "message", "pattern": "/./", "tag": "unknown"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &testPort{}
+	router.AddRule("nginx.**", output)
+	router.AddRule("**", sink)
+	records := []ik.TinyFluentRecord{
+		{Timestamp: time.Unix(1, 0), Data: map[string]interface{}{"status": "200"}},
+		{Timestamp: time.Unix(2, 0), Data: map[string]interface{}{"status": int64(503)}},
+		{Timestamp: time.Unix(3, 0), Data: map[string]interface{}{"status": "201"}},
+		{Timestamp: time.Unix(4, 0), Data: map[string]interface{}{"message": "?"}},
+		{Timestamp: time.Unix(5, 0), Data: map[string]interface{}{}},
+	}
+	err = router.Emit([]ik.FluentRecordSet{{Tag: "nginx.access", Records: records}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{"status.2xx.access": 2, "status.5xx.access": 1, "unknown": 1}
+	if len(sink.recordSets) != len(expected) {
+		t.Logf("%#v", sink.recordSets)
+		t.FailNow()
+	}
+	for _, recordSet := range sink.recordSets {
+		if len(recordSet.Records) != expected[recordSet.Tag] || recordSet.Hops != 1 {
+			t.Logf("%s: %d", recordSet.Tag, len(recordSet.Records))
+			t.Fail()
+		}
+	}
+}
+
+func TestRewriteTagFilterOutput_maxHops(t *testing.T) {
+	router := ik.NewFluentRouter()
+	output, err := newTestRewriteTagFilterOutput(t, router, "a.**", map[string]string{"key": "x", "pattern": "/./", "tag": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &testPort{}
+	router.AddRule("a.**", output)
+	router.AddRule("**", sink)
+	records := []ik.TinyFluentRecord{{Timestamp: time.Unix(1, 0), Data: map[string]interface{}{"x": "y"}}}
+	err = router.Emit([]ik.FluentRecordSet{
+		{Tag: "a.x", Records: records, Hops: rewriteTagFilterMaxHops - 1},
+		{Tag: "a.y", Records: records, Hops: rewriteTagFilterMaxHops},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.recordSets) != 1 || len(sink.recordSets[0].Records) != 1 || sink.recordSets[0].Hops != rewriteTagFilterMaxHops {
+		t.Logf("%#v", sink.recordSets)
+		t.Fail()
+	}
+}
+
+func TestRewriteTagFilterOutput_loop(t *testing.T) {
+	router := ik.NewFluentRouter()
+	// a static tag caught by the <match> itself is rejected up front
+	_, err := newTestRewriteTagFilterOutput(t, router, "app.**", map[string]string{"key": "a", "pattern": "x", "tag": "app.x"})
+	if err == nil {
+		t.Fail()
+	}
+	output, err := newTestRewriteTagFilterOutput(t, router, "app.**",
+		map[string]string{"key": "a", "pattern": "x", "tag": "${tag}"},
+		map[string]string{"key": "b", "pattern": "x", "tag": "app.${b}"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &testPort{}
+	router.AddRule("app.**", output)
+	router.AddRule("**", sink)
+	err = router.Emit([]ik.FluentRecordSet{
+		{
+			Tag: "app.a",
+			Records: []ik.TinyFluentRecord{
+				{Timestamp: time.Unix(1, 0), Data: map[string]interface{}{"a": "x"}},
+				{Timestamp: time.Unix(1, 0), Data: map[string]interface{}{"b": "x"}},
+			},
+		},
+	})
+	if err != nil || len(sink.recordSets) != 0 {
+		t.Fail()
+	}
+}
+
+func TestRewriteTagFilterOutput_indirectLoop(t *testing.T) {
+	router := ik.NewFluentRouter()
+	a, err := newTestRewriteTagFilterOutput(t, router, "a.**", map[string]string{"key": "x", "pattern": "/./", "tag": "b.${tag}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newTestRewriteTagFilterOutput(t, router, "b.**", map[string]string{"key": "x", "pattern": "/./", "tag": "a.${tag}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &testPort{}
+	router.AddRule("a.**", a)
+	router.AddRule("b.**", b)
+	router.AddRule("**", sink)
+	err = router.Emit([]ik.FluentRecordSet{
+		{
+			Tag:     "a.x",
+			Records: []ik.TinyFluentRecord{{Timestamp: time.Unix(1, 0), Data: map[string]interface{}{"x": "y"}}},
+		},
+	})
+	if err != nil || len(sink.recordSets) != 0 {
+		t.Fail()
+	}
+}
@@ -15,7 +15,7 @@ type StdoutOutput struct {
 func (output *StdoutOutput) Emit(recordSets []ik.FluentRecordSet) error {
 	for _, recordSet := range recordSets {
 		for _, record := range recordSet.Records {
-			fmt.Fprintf(os.Stdout, "%d %s: %s\n", record.Timestamp, recordSet.Tag, record.Data)
+			fmt.Fprintf(os.Stdout, "%d.%09d %s: %s\n", record.Timestamp.Unix(), record.Timestamp.Nanosecond(), recordSet.Tag, record.Data)
 		}
 	}
 	return nil
@@ -0,0 +1,151 @@
+package plugins
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"TLSV1":   tls.VersionTLS10,
+	"TLSV1_0": tls.VersionTLS10,
+	"TLSV1_1": tls.VersionTLS11,
+	"TLSV1_2": tls.VersionTLS12,
+	"TLSV1_3": tls.VersionTLS13,
+	"1.0":     tls.VersionTLS10,
+	"1.1":     tls.VersionTLS11,
+	"1.2":     tls.VersionTLS12,
+	"1.3":     tls.VersionTLS13,
+}
+
+func parseTLSVersion(s string) (uint16, error) {
+	version, ok := tlsVersions[strings.ToUpper(s)]
+	if !ok {
+		return 0, errors.New("unknown TLS version: " + s)
+	}
+	return version, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New(fmt.Sprintf("no certificates found in %s", path))
+	}
+	return pool, nil
+}
+
+// newServerTLSConfig builds the TLS configuration for in_forward from
+// <transport tls>.  nil is returned if the transport is not TLS.
+func newServerTLSConfig(config *ik.ConfigElement) (*tls.Config, error) {
+	transportConfig := findConfigElement(config, "transport")
+	if transportConfig == nil || transportConfig.Args == "tcp" {
+		return nil, nil
+	}
+	if transportConfig.Args != "tls" {
+		return nil, errors.New("unsupported transport: " + transportConfig.Args)
+	}
+	certPath, ok := transportConfig.Attrs["cert_path"]
+	if !ok {
+		return nil, errors.New("required attribute `cert_path' is not specified in <transport>")
+	}
+	privateKeyPath, ok := transportConfig.Attrs["private_key_path"]
+	if !ok {
+		return nil, errors.New("required attribute `private_key_path' is not specified in <transport>")
+	}
+	cert, err := tls.LoadX509KeyPair(certPath, privateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	caPath, ok := transportConfig.Attrs["ca_path"]
+	if ok {
+		tlsConfig.ClientCAs, err = loadCertPool(caPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	clientCertAuthStr, ok := transportConfig.Attrs["client_cert_auth"]
+	if ok {
+		clientCertAuth, err := strconv.ParseBool(clientCertAuthStr)
+		if err != nil {
+			return nil, err
+		}
+		if clientCertAuth {
+			if tlsConfig.ClientCAs == nil {
+				return nil, errors.New("client_cert_auth requires `ca_path'")
+			}
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	minVersionStr, ok := transportConfig.Attrs["min_version"]
+	if ok {
+		tlsConfig.MinVersion, err = parseTLSVersion(minVersionStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tlsConfig, nil
+}
+
+// newClientTLSConfig builds the TLS configuration for out_forward from the
+// tls_* attributes.  nil is returned if the transport is not TLS.
+func newClientTLSConfig(config *ik.ConfigElement) (*tls.Config, error) {
+	transport, ok := config.Attrs["transport"]
+	if !ok || transport == "tcp" {
+		return nil, nil
+	}
+	if transport != "tls" {
+		return nil, errors.New("unsupported transport: " + transport)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	var err error
+	caPath, ok := config.Attrs["tls_cert_path"]
+	if ok {
+		tlsConfig.RootCAs, err = loadCertPool(caPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	clientCertPath, ok := config.Attrs["tls_client_cert_path"]
+	if ok {
+		clientPrivateKeyPath, ok := config.Attrs["tls_client_private_key_path"]
+		if !ok {
+			return nil, errors.New("`tls_client_cert_path' requires `tls_client_private_key_path'")
+		}
+		cert, err := tls.LoadX509KeyPair(clientCertPath, clientPrivateKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	verifyHostnameStr, ok := config.Attrs["tls_verify_hostname"]
+	if ok {
+		verifyHostname, err := strconv.ParseBool(verifyHostnameStr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.InsecureSkipVerify = !verifyHostname
+	}
+	minVersionStr, ok := config.Attrs["tls_min_version"]
+	if ok {
+		tlsConfig.MinVersion, err = parseTLSVersion(minVersionStr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,211 @@
+package plugins
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/moriyoshi/ik"
+	"io/ioutil"
+	"math/big"
+	mathrand "math/rand"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func generateTestCertificate(t *testing.T, dir string, name string, isCA bool, issuer *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	parent, parentKey := template, key
+	if issuer != nil {
+		parent, parentKey = issuer.cert, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCertificate{cert, key}
+}
+
+func doTestTLSForward(t *testing.T, serverConfig *ik.ConfigElement, clientConfig *ik.ConfigElement) (*testPort, error) {
+	serverTLSConfig, err := newServerTLSConfig(serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientTLSConfig, err := newClientTLSConfig(clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := &testPort{}
+	logger := &testLogger{t}
+	input, err := newForwardInput(&ForwardInputFactory{}, logger, nil, "127.0.0.1:0", port, nil, serverTLSConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Shutdown()
+	go input.Run()
+	server := &forwardServer{
+		name:      "test",
+		bind:      input.listener.Addr().String(),
+		weight:    1,
+		tlsConfig: clientTLSConfig,
+		available: true,
+	}
+	bufferDir, err := ioutil.TempDir("", "ik-tls-test-buffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(bufferDir)
+	output, err := newForwardOutput(&ForwardOutputFactory{}, logger, mathrand.NewSource(0), path.Join(bufferDir, "buffer"), 1024*1024, []*forwardServer{server}, compressionNone, false, true, 5*time.Second, "none", time.Second, time.Minute, nil, &ik.RetryPolicy{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Shutdown()
+	err = output.Emit([]ik.FluentRecordSet{
+		{
+			Tag:     "test.tag",
+			Records: []ik.TinyFluentRecord{{Timestamp: time.Unix(1, 0), Data: map[string]interface{}{"a": "b"}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return port, output.flush()
+}
+
+func newTestTLSConfigs(dir string) (*ik.ConfigElement, *ik.ConfigElement) {
+	serverConfig := &ik.ConfigElement{
+		Name:  "source",
+		Attrs: map[string]string{},
+		Elems: []*ik.ConfigElement{
+			{
+				Name: "transport",
+				Args: "tls",
+				Attrs: map[string]string{
+					"cert_path":        path.Join(dir, "server.crt"),
+					"private_key_path": path.Join(dir, "server.key"),
+					"ca_path":          path.Join(dir, "ca.crt"),
+					"client_cert_auth": "true",
+					"min_version":      "TLSv1_2",
+				},
+			},
+		},
+	}
+	clientConfig := &ik.ConfigElement{
+		Name: "match",
+		Attrs: map[string]string{
+			"transport":     "tls",
+			"tls_cert_path": path.Join(dir, "ca.crt"),
+		},
+	}
+	return serverConfig, clientConfig
+}
+
+func TestForwardTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ik-tls-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := generateTestCertificate(t, dir, "ca", true, nil)
+	generateTestCertificate(t, dir, "server", false, ca)
+	generateTestCertificate(t, dir, "client", false, ca)
+	serverConfig, clientConfig := newTestTLSConfigs(dir)
+	clientConfig.Attrs["tls_client_cert_path"] = path.Join(dir, "client.crt")
+	clientConfig.Attrs["tls_client_private_key_path"] = path.Join(dir, "client.key")
+	port, err := doTestTLSForward(t, serverConfig, clientConfig)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if len(port.recordSets) != 1 || port.recordSets[0].Tag != "test.tag" {
+		t.Fail()
+	}
+}
+
+func TestForwardTLS_clientCertRequired(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ik-tls-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := generateTestCertificate(t, dir, "ca", true, nil)
+	generateTestCertificate(t, dir, "server", false, ca)
+	serverConfig, clientConfig := newTestTLSConfigs(dir)
+	port, err := doTestTLSForward(t, serverConfig, clientConfig)
+	if err == nil || len(port.recordSets) != 0 {
+		t.Fail()
+	}
+}
+
+func TestForwardTLS_untrustedServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ik-tls-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := generateTestCertificate(t, dir, "ca", true, nil)
+	generateTestCertificate(t, dir, "server", false, nil)
+	generateTestCertificate(t, dir, "client", false, ca)
+	serverConfig, clientConfig := newTestTLSConfigs(dir)
+	clientConfig.Attrs["tls_client_cert_path"] = path.Join(dir, "client.crt")
+	clientConfig.Attrs["tls_client_private_key_path"] = path.Join(dir, "client.key")
+	_, err = doTestTLSForward(t, serverConfig, clientConfig)
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	for _, s := range []string{"TLSv1_2", "tlsv1_3", "1.2"} {
+		_, err := parseTLSVersion(s)
+		if err != nil {
+			t.Log(err.Error())
+			t.Fail()
+		}
+	}
+	_, err := parseTLSVersion("SSLv3")
+	if err == nil {
+		t.Fail()
+	}
+}
@@ -0,0 +1,181 @@
+package ik
+
+import (
+	"errors"
+	"fmt"
+	"github.com/moriyoshi/ik/task"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+type RetryPolicy struct {
+	RetryWait    time.Duration
+	MaxRetryWait time.Duration // no upper bound if zero
+	RetryLimit   int           // no limit if negative
+	RetryTimeout time.Duration // no timeout if zero
+	Jitter       float64       // ratio of the randomization applied to each wait
+}
+
+// RetryState keeps track of the consecutive failures of a single task
+// (typically a flush of a chunk) under a RetryPolicy.
+type RetryState struct {
+	policy       *RetryPolicy
+	rand         *rand.Rand
+	retries      int
+	firstFailure time.Time
+}
+
+func parseRetryDuration(config *ConfigElement, name string, defaultValue string) (time.Duration, error) {
+	s, ok := config.Attrs[name]
+	if !ok {
+		s = defaultValue
+	}
+	retval, err := ParseDurationString(s)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Failed to parse %s: %s", name, err.Error()))
+	}
+	return retval, nil
+}
+
+// NewRetryPolicy builds a RetryPolicy from retry_wait, max_retry_wait,
+// retry_limit, retry_timeout and retry_jitter.
+func NewRetryPolicy(config *ConfigElement) (*RetryPolicy, error) {
+	retryWait, err := parseRetryDuration(config, "retry_wait", "1s")
+	if err != nil {
+		return nil, err
+	}
+	maxRetryWait, err := parseRetryDuration(config, "max_retry_wait", "0")
+	if err != nil {
+		return nil, err
+	}
+	retryTimeout, err := parseRetryDuration(config, "retry_timeout", "0")
+	if err != nil {
+		return nil, err
+	}
+	retryLimitStr, ok := config.Attrs["retry_limit"]
+	if !ok {
+		retryLimitStr = "17"
+	}
+	retryLimit, err := strconv.Atoi(retryLimitStr)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to parse retry_limit: %s", err.Error()))
+	}
+	jitterStr, ok := config.Attrs["retry_jitter"]
+	if !ok {
+		jitterStr = "0.125"
+	}
+	jitter, err := strconv.ParseFloat(jitterStr, 64)
+	if err != nil || jitter < 0 || jitter > 1 {
+		return nil, errors.New("retry_jitter must be between 0 and 1: " + jitterStr)
+	}
+	return &RetryPolicy{
+		RetryWait:    retryWait,
+		MaxRetryWait: maxRetryWait,
+		RetryLimit:   retryLimit,
+		RetryTimeout: retryTimeout,
+		Jitter:       jitter,
+	}, nil
+}
+
+// Wait returns the interval before the next retry after the given number
+// of retries, that is doubled every time and capped by MaxRetryWait.
+func (policy *RetryPolicy) Wait(retries int, random *rand.Rand) time.Duration {
+	wait := float64(policy.RetryWait) * math.Pow(2, float64(retries))
+	if policy.MaxRetryWait > 0 && wait > float64(policy.MaxRetryWait) {
+		wait = float64(policy.MaxRetryWait)
+	}
+	if policy.Jitter > 0 && random != nil {
+		wait *= 1 + policy.Jitter*(random.Float64()*2-1)
+	}
+	if wait > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(wait)
+}
+
+func NewRetryState(policy *RetryPolicy, randSource rand.Source) *RetryState {
+	return &RetryState{
+		policy: policy,
+		rand:   rand.New(randSource),
+	}
+}
+
+func (state *RetryState) Retries() int {
+	return state.retries
+}
+
+func (state *RetryState) Reset() {
+	state.retries = 0
+	state.firstFailure = time.Time{}
+}
+
+// Failed records a failure that happened at now, and returns the time to
+// wait before retrying.  false is returned if the retries are exhausted.
+func (state *RetryState) Failed(now time.Time) (time.Duration, bool) {
+	if state.firstFailure.IsZero() {
+		state.firstFailure = now
+	}
+	policy := state.policy
+	if policy.RetryLimit >= 0 && state.retries >= policy.RetryLimit {
+		return 0, false
+	}
+	if policy.RetryTimeout > 0 && now.Sub(state.firstFailure) >= policy.RetryTimeout {
+		return 0, false
+	}
+	wait := policy.Wait(state.retries, state.rand)
+	state.retries += 1
+	return wait, true
+}
+
+// ScheduleOnce runs fn once at the specified time on the scheduler.
+func ScheduleOnce(scheduler *task.RecurringTaskScheduler, at time.Time, fn func()) error {
+	_, err := scheduler.RegisterTask(
+		task.NewOneShotTaskSpec(at),
+		func(int64, time.Time, *task.RecurringTaskSpec) (interface{}, error) {
+			fn()
+			return nil, nil
+		},
+	)
+	return err
+}
+
+// NewSecondaryOutput instantiates the output described by the <secondary>
+// element, which receives the records whose retries are exhausted.  nil is
+// returned if no such element is given.
+func NewSecondaryOutput(engine Engine, config *ConfigElement) (Output, error) {
+	var secondaryConfig *ConfigElement
+	for _, elem := range config.Elems {
+		if elem.Name == "secondary" {
+			if secondaryConfig != nil {
+				return nil, errors.New("multiple <secondary> elements are specified")
+			}
+			secondaryConfig = elem
+		}
+	}
+	if secondaryConfig == nil {
+		return nil, nil
+	}
+	type_ := secondaryConfig.Type()
+	if type_ == "" {
+		return nil, errors.New("'type' is not specified in <secondary>")
+	}
+	registry := engine.OutputFactoryRegistry()
+	if registry == nil {
+		return nil, errors.New("no output plugins are available for <secondary>")
+	}
+	factory := registry.LookupOutputFactory(type_)
+	if factory == nil {
+		return nil, errors.New("Could not find output factory: " + type_)
+	}
+	output, err := factory.New(engine, secondaryConfig)
+	if err != nil {
+		return nil, err
+	}
+	err = engine.Launch(output)
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
@@ -0,0 +1,98 @@
+package ik
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestParseDurationString(t *testing.T) {
+	cases := map[string]time.Duration{
+		"30":   30 * time.Second,
+		"1.5s": 1500 * time.Millisecond,
+		"10m":  10 * time.Minute,
+		"1h":   time.Hour,
+		"2d":   48 * time.Hour,
+	}
+	for s, expected := range cases {
+		d, err := ParseDurationString(s)
+		if err != nil || d != expected {
+			t.Logf("%s: %v %v", s, d, err)
+			t.Fail()
+		}
+	}
+	_, err := ParseDurationString("1w")
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestNewRetryPolicy(t *testing.T) {
+	policy, err := NewRetryPolicy(&ConfigElement{
+		Attrs: map[string]string{
+			"retry_wait":     "2s",
+			"max_retry_wait": "1m",
+			"retry_limit":    "5",
+			"retry_jitter":   "0",
+		},
+	})
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if policy.RetryWait != 2*time.Second || policy.MaxRetryWait != time.Minute || policy.RetryLimit != 5 || policy.RetryTimeout != 0 {
+		t.Fail()
+	}
+	_, err = NewRetryPolicy(&ConfigElement{Attrs: map[string]string{"retry_jitter": "2"}})
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestRetryState_Failed(t *testing.T) {
+	policy := &RetryPolicy{
+		RetryWait:    time.Second,
+		MaxRetryWait: 5 * time.Second,
+		RetryLimit:   4,
+	}
+	state := NewRetryState(policy, rand.NewSource(0))
+	now := time.Unix(0, 0)
+	for _, expected := range []time.Duration{1, 2, 4, 5} {
+		wait, ok := state.Failed(now)
+		if !ok || wait != expected*time.Second {
+			t.Logf("%v %v", wait, ok)
+			t.Fail()
+		}
+	}
+	_, ok := state.Failed(now)
+	if ok {
+		t.Fail()
+	}
+	state.Reset()
+	if state.Retries() != 0 {
+		t.Fail()
+	}
+
+	policy.RetryLimit = -1
+	policy.RetryTimeout = time.Minute
+	_, ok = state.Failed(now)
+	if !ok {
+		t.Fail()
+	}
+	_, ok = state.Failed(now.Add(time.Minute))
+	if ok {
+		t.Fail()
+	}
+}
+
+func TestRetryPolicy_jitter(t *testing.T) {
+	policy := &RetryPolicy{RetryWait: time.Second, Jitter: 0.5}
+	random := rand.New(rand.NewSource(0))
+	for i := 0; i < 100; i += 1 {
+		wait := policy.Wait(0, random)
+		if wait < 500*time.Millisecond || wait > 1500*time.Millisecond {
+			t.Log(wait)
+			t.Fail()
+		}
+	}
+}
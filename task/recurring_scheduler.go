@@ -1,7 +1,9 @@
 package task
 
 import (
+	"container/heap"
 	"errors"
+	"math"
 	"sort"
 	"sync/atomic"
 	"time"
@@ -71,70 +73,70 @@ type RecurringTaskScheduler struct {
 	nextId     int64
 }
 
-func (heap *RecurringTaskDescriptorHeap) insert(elem *RecurringTaskDescriptor) {
-	l := len(*heap)
-	if cap(*heap) < l+1 {
-		newCap := cap(*heap) * 2
-		if newCap < cap(*heap) {
-			panic("?")
-		}
-		newHeap := make([]*RecurringTaskDescriptor, newCap, l+1)
-		copy(newHeap, *heap)
-		*heap = newHeap
-	} else {
-		*heap = (*heap)[0 : l+1]
+func (pq RecurringTaskDescriptorHeap) Len() int {
+	return len(pq)
+}
+
+// Less places the stopped task that comes first at the top; the running
+// ones sink below the stopped ones.
+func (pq RecurringTaskDescriptorHeap) Less(i, j int) bool {
+	if pq[i].status != pq[j].status {
+		return pq[i].status != Running
 	}
-	(*heap)[l] = elem
-	heap.bubbleUp(l)
+	return pq[i].nextTime.Before(pq[j].nextTime)
+}
+
+func (pq RecurringTaskDescriptorHeap) Swap(i, j int) {
+	pq[i], pq[j] = pq[j], pq[i]
+}
+
+func (pq *RecurringTaskDescriptorHeap) Push(x interface{}) {
+	*pq = append(*pq, x.(*RecurringTaskDescriptor))
+}
+
+func (pq *RecurringTaskDescriptorHeap) Pop() interface{} {
+	_pq := *pq
+	l := len(_pq)
+	retval := _pq[l-1]
+	_pq[l-1] = nil
+	*pq = _pq[0 : l-1]
+	return retval
+}
+
+func (pq *RecurringTaskDescriptorHeap) insert(elem *RecurringTaskDescriptor) {
+	heap.Push(pq, elem)
 }
 
-func (heap *RecurringTaskDescriptorHeap) find(elem *RecurringTaskDescriptor) int {
-	_heap := *heap
-	for i := 0; i < len(_heap); i += 1 {
-		if _heap[i] == elem {
+func (pq *RecurringTaskDescriptorHeap) find(elem *RecurringTaskDescriptor) int {
+	_pq := *pq
+	for i := 0; i < len(_pq); i += 1 {
+		if _pq[i] == elem {
 			return i
 		}
 	}
 	return -1
 }
 
-func (heap *RecurringTaskDescriptorHeap) update(elem *RecurringTaskDescriptor) {
-	i := heap.find(elem)
+func (pq *RecurringTaskDescriptorHeap) update(elem *RecurringTaskDescriptor) {
+	i := pq.find(elem)
 	if i < 0 {
 		panic("should never happen")
 	}
-	_heap := *heap
-	copy(_heap[i:], _heap[i+1:])
-	i = len(_heap) - 1
-	_heap[i] = elem
-	heap.bubbleUp(i)
+	heap.Fix(pq, i)
 }
 
-func (heap *RecurringTaskDescriptorHeap) delete(elem *RecurringTaskDescriptor) {
-	i := heap.find(elem)
+func (pq *RecurringTaskDescriptorHeap) delete(elem *RecurringTaskDescriptor) {
+	i := pq.find(elem)
 	if i < 0 {
 		panic("should never happen")
 	}
-	_heap := *heap
-	copy(_heap[i:], _heap[i+1:])
-	// readjust the capacity
-	*heap = _heap[0 : len(_heap)-1]
+	heap.Remove(pq, i)
 }
 
-func (heap *RecurringTaskDescriptorHeap) bubbleUp(i int) {
-	for i > 0 {
-		node := &(*heap)[i]
-		parentIndex := (i - 1) >> 1
-		parentNode := &(*heap)[parentIndex]
-		if (*parentNode).status != Running && (*node).status == Running {
-			break
-		} else if ((*parentNode).status == Running && (*node).status != Running) || (*parentNode).nextTime.After((*node).nextTime) {
-			*node, *parentNode = *parentNode, *node
-			i = parentIndex
-		} else {
-			break
-		}
-	}
+// NewOneShotTaskSpec returns a spec for a task that runs only once at the
+// specified time.
+func NewOneShotTaskSpec(at time.Time) RecurringTaskSpec {
+	return RecurringTaskSpec{rightAt: at}
 }
 
 func (spec *RecurringTaskSpec) copy() RecurringTaskSpec {
@@ -392,7 +394,7 @@ func (sched *RecurringTaskScheduler) RunNext() (time.Duration, TaskStatus, error
 	result := <-resultChan
 	descr := result.descriptor
 	remaining := result.diff
-	if remaining > 0 {
+	if descr == nil || remaining > 0 {
 		return remaining, nil, nil
 	}
 	taskStatus, err := sched.taskRunner.Run(func() (interface{}, error) {
@@ -401,7 +403,8 @@ func (sched *RecurringTaskScheduler) RunNext() (time.Duration, TaskStatus, error
 		if err != nil {
 			return nil, err
 		}
-		if spec.isZero() {
+		if spec.isZero() || (!spec.rightAt.IsZero() && !spec.rightAt.After(descr.nextTime)) {
+			// one-shot tasks are removed unless rescheduled
 			sched.daemonChan <- RecurringTaskDaemonCommand{Delete, descr, time.Time{}, nil}
 		} else {
 			_now := sched.nowGetter()
@@ -438,9 +441,13 @@ func (sched *RecurringTaskScheduler) ProcessEvent() {
 		descr := cmd.descriptor
 		descr.nextTime = cmd.time
 		descr.status = Stopped
-		sched.pQueue.update(descr)
 		(&sched.pQueue).update(descr)
 	case TryPop:
+		if len(sched.pQueue) == 0 || sched.pQueue[0].status == Running {
+			// nothing to run for now
+			cmd.result <- RecurringTaskDaemonCommandResult{nil, time.Duration(math.MaxInt64)}
+			break
+		}
 		descr := sched.pQueue[0]
 		now := cmd.time
 		diff := descr.nextTime.Sub(now)
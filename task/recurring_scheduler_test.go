@@ -255,3 +255,56 @@ func TestGoal(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestOneShot(t *testing.T) {
+	var now time.Time
+	runner := &DummyTaskRunner{}
+	sched := NewRecurringTaskScheduler(func() time.Time { return now }, runner)
+	count := 0
+
+	now = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err := sched.RegisterTask(
+		NewOneShotTaskSpec(now.Add(time.Second)),
+		func(id int64, on time.Time, spec *RecurringTaskSpec) (interface{}, error) {
+			count += 1
+			return nil, nil
+		},
+	)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	sched.ProcessEvent()
+
+	go sched.ProcessEvent()
+	diff, _, err := sched.RunNext()
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if diff != time.Second || count != 0 {
+		t.Fail()
+	}
+
+	now = now.Add(time.Second)
+	go sched.ProcessEvent()
+	diff, _, err = sched.RunNext()
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if diff != 0 || count != 1 {
+		t.Fail()
+	}
+	sched.ProcessEvent() // for delete
+
+	// the queue is now empty
+	go sched.ProcessEvent()
+	diff, status, err := sched.RunNext()
+	if err != nil || status != nil {
+		t.FailNow()
+	}
+	if diff <= 0 || count != 1 {
+		t.Fail()
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"math/rand"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -50,6 +51,40 @@ func ParseCapacityString(s string) (int64, error) {
 	return multiply * i, nil
 }
 
+var durationRegExp = regexp.MustCompile("^([0-9]+(?:\\.[0-9]*)?)([smhd])?$")
+
+// ParseDurationString parses a duration in fluentd's notation, such as
+// "30", "1.5s", "10m", "1h" or "2d".  A bare number is taken as seconds.
+func ParseDurationString(s string) (time.Duration, error) {
+	m := durationRegExp.FindStringSubmatch(s)
+	if m == nil {
+		return 0, errors.New("Invalid format: " + s)
+	}
+	f, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, errors.New("Invalid format: " + s)
+	}
+	unit := time.Second
+	switch m[2] {
+	case "m":
+		unit = time.Minute
+	case "h":
+		unit = time.Hour
+	case "d":
+		unit = 24 * time.Hour
+	}
+	return time.Duration(f * float64(unit)), nil
+}
+
 func NewRandSourceWithTimestampSeed() rand.Source {
 	return rand.NewSource(time.Now().UnixNano())
 }
+
+// StripRegexpDelimiters removes the slashes around "/pattern/", in which
+// the regular expressions are given in the configuration as in fluentd.
+func StripRegexpDelimiters(pattern string) string {
+	if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		return pattern[1 : len(pattern)-1]
+	}
+	return pattern
+}